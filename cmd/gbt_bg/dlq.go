@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	gbtconsumer "github.com/golang-base-template/pkg/consumer"
+	redisClient "github.com/golang-base-template/util/cache/client"
+	"github.com/golang-base-template/util/config"
+	"github.com/golang-base-template/util/stream"
+)
+
+const (
+	dlqUsage        = "usage: gbt_bg dlq inspect|replay <consumer> [limit]"
+	defaultDLQLimit = 10
+	dlqTimeout      = time.Minute
+)
+
+// runDLQ runs admin command on dead letter queue of a ConsumerList entry:
+//
+//	gbt_bg dlq inspect <consumer> [limit]	prints dead letter messages without removing them
+//	gbt_bg dlq replay <consumer> [limit]	publishes dead letter messages back to the source topic
+func runDLQ(cfg *config.Config, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New(dlqUsage)
+	}
+
+	command, name := args[0], args[1]
+	value, ok := cfg.ConsumerList[name]
+	if !ok {
+		return errors.Errorf("%s has no ConsumerList config", name)
+	}
+
+	limit := defaultDLQLimit
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n <= 0 {
+			return errors.Errorf("invalid limit %s, %s", args[2], dlqUsage)
+		}
+		limit = n
+	}
+
+	if value.Backend == stream.Backend {
+		if err := redisClient.InitRedis([]string{value.Redis}); err != nil {
+			return errors.Wrapf(err, "fail to init redis %s", value.Redis)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dlqTimeout)
+	defer cancel()
+
+	switch command {
+	case "inspect":
+		count := 0
+		err := gbtconsumer.InspectDLQ(ctx, cfg, name, limit, func(dl gbtconsumer.DeadLetterMessage) {
+			count++
+			fmt.Printf("#%d id: %s, topic: %s, channel: %s, attempts: %d, failed at: %s\n", count, dl.ID, dl.Topic, dl.Channel,
+				dl.Attempts, time.Unix(0, dl.FailedAt).Format(time.RFC3339))
+			fmt.Printf("   error: %s\n   body: %s\n", dl.Error, dl.Body)
+		})
+		fmt.Printf("%d messages in %s\n", count, gbtconsumer.DLQTopic(value))
+		return err
+
+	case "replay":
+		n, err := gbtconsumer.ReplayDLQ(ctx, cfg, name, limit)
+		fmt.Printf("%d messages of %s are replayed to %s\n", n, gbtconsumer.DLQTopic(value), value.Topic)
+		return err
+	}
+
+	return errors.New(dlqUsage)
+}
+
+// dlqCommand runs dlq admin command and exits if it is given as argument
+func dlqCommand(cfg *config.Config) {
+	if flag.Arg(0) != "dlq" {
+		return
+	}
+
+	err := runDLQ(cfg, flag.Args()[1:])
+	if err != nil {
+		log.Println("[DLQ]", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
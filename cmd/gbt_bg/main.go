@@ -1,20 +1,30 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/urfave/negroni"
 
 	"github.com/golang-base-template/cmd"
 	gbtconsumer "github.com/golang-base-template/pkg/consumer"
+	// register consumer handlers
+	_ "github.com/golang-base-template/pkg/consumer/ping"
 	redisClient "github.com/golang-base-template/util/cache/client"
 	"github.com/golang-base-template/util/config"
 	databaseClient "github.com/golang-base-template/util/database/client"
 	gbtserve "github.com/golang-base-template/util/serve"
 )
 
+// defaultShutdownTimeout is how long in-flight messages are waited on shutdown
+const defaultShutdownTimeout = 30 * time.Second
+
 func main() {
+	flag.Parse()
+
 	err := config.InitConfig()
 	if err != nil {
 		msg := fmt.Sprintf("error when init http config: %+v", err)
@@ -37,13 +47,33 @@ func main() {
 		log.Fatalln(msg)
 	}
 
-	gbtconsumer.Init(&cfg)
+	// admin command, e.g. gbt_bg dlq inspect ping-consumer
+	dlqCommand(&cfg)
+
+	err = gbtconsumer.Init(&cfg)
+	if err != nil {
+		msg := fmt.Sprintf("error when init consumer: %+v", err)
+		log.Fatalln(msg)
+	}
 
 	n := negroni.New()
 
+	// Serve blocks until SIGINT or SIGTERM is received
 	err = gbtserve.Serve(fmt.Sprintf(":%s", cfg.Port.Bg), n)
 	if err != nil {
 		log.Println("error when serve http app")
-		return
 	}
+
+	shutdownTimeout := defaultShutdownTimeout
+	if cfg.Consumer.ShutdownTimeoutSec > 0 {
+		shutdownTimeout = time.Duration(cfg.Consumer.ShutdownTimeoutSec) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = gbtconsumer.Stop(ctx)
+	if err != nil {
+		log.Println("error when stop consumer:", err)
+	}
+	cmd.CloseApp()
 }
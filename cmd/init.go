@@ -44,8 +44,8 @@ func InitApp(appConfig config.Config, dbs databaseClient.DatabaseList, rds redis
 		}
 	}
 	if len(rds.NonCriticalRedis) != 0 {
-		log.Println("Initiating Non Critical Redis:", rds.CriticalRedis)
-		err = redisClient.InitRedis(rds.CriticalRedis)
+		log.Println("Initiating Non Critical Redis:", rds.NonCriticalRedis)
+		err = redisClient.InitRedis(rds.NonCriticalRedis)
 		if err != nil {
 			log.Println("[Init] Error when init", handleErr("nonCriticalRedis", err))
 		}
@@ -57,6 +57,23 @@ func InitApp(appConfig config.Config, dbs databaseClient.DatabaseList, rds redis
 	return nil
 }
 
+// CloseApp stops nsq publishers and closes database and redis connections, call it after every consumer
+// and server is stopped so nothing uses them anymore
+func CloseApp() {
+	log.Println("Stopping NSQ Publisher")
+	nsqpublisher.Stop()
+
+	log.Println("Closing DB")
+	if err := databaseClient.CloseDB(); err != nil {
+		log.Println("[Close] Error when close", handleErr("DB:", err))
+	}
+
+	log.Println("Closing Redis")
+	if err := redisClient.CloseRedis(); err != nil {
+		log.Println("[Close] Error when close Redis:", err)
+	}
+}
+
 // handleErr func to mask db password in log
 func handleErr(label string, e error) (err error) {
 	if e != nil {
@@ -1,12 +1,12 @@
 module github.com/golang-base-template
 
-go 1.14
+go 1.18
 
 require (
 	github.com/alicebob/miniredis/v2 v2.17.0
 	github.com/allegro/bigcache/v3 v3.1.0
 	github.com/bitly/go-nsq v1.0.7
-	github.com/golang/snappy v0.0.4 // indirect
+	github.com/golang/snappy v0.0.4
 	github.com/jmoiron/sqlx v1.3.5
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.2.0
@@ -14,9 +14,18 @@ require (
 	github.com/onsi/ginkgo v1.16.5 // indirect
 	github.com/onsi/gomega v1.27.4 // indirect
 	github.com/pkg/errors v0.9.1
+	github.com/redis/go-redis/v9 v9.7.3
 	github.com/urfave/negroni v1.0.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	golang.org/x/sync v0.6.0
+	google.golang.org/protobuf v1.28.0
 	gopkg.in/bitly/go-nsq.v1 v1.0.7
-	gopkg.in/redis.v5 v5.2.9
 	gopkg.in/tylerb/graceful.v1 v1.2.15
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+)
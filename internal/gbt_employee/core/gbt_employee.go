@@ -48,11 +48,11 @@ func (g *gbtEmployeeCore) Save(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	err = rp.HMSet(ctx, "", map[string]string{})
+	_, err = rp.HMSet(ctx, "", map[string]string{})
 	if err != nil {
 		return err
 	}
-	err = rp.Expire(ctx, "", time.Duration(0))
+	_, err = rp.Expire(ctx, "", time.Duration(0))
 	if err != nil {
 		return err
 	}
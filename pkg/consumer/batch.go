@@ -0,0 +1,233 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bitly/go-nsq"
+	"github.com/pkg/errors"
+
+	"github.com/golang-base-template/util/config"
+)
+
+// defaultBatchWait is how long the first message of a batch waits for the batch to be full
+const defaultBatchWait = 100 * time.Millisecond
+
+var (
+	// batchers are batchers of running consumers, their pending batches are flushed by Stop
+	batchers   []*batcher
+	mxBatchers sync.Mutex
+)
+
+type (
+	// BatchHandler handles messages at once, e.g. one DB round-trip for many small events.
+	// It return error of each message in the same order: nil finishes the message and error requeues it
+	// or moves it to dead letter topic like error of HandleMessage (see Retry, Skip and Permanent).
+	// Nil or shorter slice means the missing messages succeeded.
+	BatchHandler interface {
+		HandleBatch(ctx context.Context, messages []*nsq.Message) []error
+	}
+
+	// BatchHandlerFunc is BatchHandler func, it also implements nsq.Handler so it can be returned by HandlerFactory
+	BatchHandlerFunc func(ctx context.Context, messages []*nsq.Message) []error
+
+	// batcher accumulates messages of a consumer worker up to BatchSize or BatchWaitMS and handles them at once
+	batcher struct {
+		handler  BatchHandler
+		value    *config.ConsumerListConfig
+		size     int
+		wait     time.Duration
+		timeout  time.Duration
+		interval time.Duration
+
+		// result responds each message of handled batch by its error through dead letter handler
+		result  *deadLetterHandler
+		results sync.Map
+
+		mx      sync.Mutex
+		pending []*nsq.Message
+		timer   *time.Timer
+		// batchID identifies pending batch, so timer of taken batch does not flush the next one
+		batchID uint64
+	}
+)
+
+// HandleMessage handles the message as a batch of one message
+func (f BatchHandlerFunc) HandleMessage(message *nsq.Message) error {
+	return f.HandleMessageContext(handlerCtx, message)
+}
+
+func (f BatchHandlerFunc) HandleMessageContext(ctx context.Context, message *nsq.Message) error {
+	if errs := f(ctx, []*nsq.Message{message}); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+func (f BatchHandlerFunc) HandleBatch(ctx context.Context, messages []*nsq.Message) []error {
+	return f(ctx, messages)
+}
+
+// Batch return handler accumulating messages for BatchHandler of the consumer config. The batch is handled
+// once it has BatchSize messages or its first message waits for BatchWaitMS, or consumers are stopped.
+// Middlewares are not applied per message so Init rejects them with BatchSize, the batch is recovered from panic
+// and has message context with handler timeout, and its messages are touched while it is handled.
+func Batch(handler BatchHandler, cfg *config.Config, value *config.ConsumerListConfig) nsq.Handler {
+	b := &batcher{
+		handler:  handler,
+		value:    value,
+		size:     value.BatchSize,
+		wait:     defaultBatchWait,
+		timeout:  handlerTimeout(value),
+		interval: msgTimeout(value) / 2,
+	}
+	if value.BatchWaitMS > 0 {
+		b.wait = time.Duration(value.BatchWaitMS) * time.Millisecond
+	}
+
+	b.result = DeadLetter(nsq.HandlerFunc(func(message *nsq.Message) error {
+		if err, ok := b.results.Load(message); ok {
+			return err.(error)
+		}
+		return nil
+	}), cfg, value).(*deadLetterHandler)
+
+	mxBatchers.Lock()
+	batchers = append(batchers, b)
+	mxBatchers.Unlock()
+
+	return b
+}
+
+// flushBatches handles pending batch of every batcher at once
+func flushBatches() {
+	mxBatchers.Lock()
+	all := batchers
+	batchers = nil
+	mxBatchers.Unlock()
+
+	var wg sync.WaitGroup
+	for _, b := range all {
+		wg.Add(1)
+		go func(b *batcher) {
+			defer wg.Done()
+			b.mx.Lock()
+			batch := b.take()
+			b.mx.Unlock()
+
+			if len(batch) > 0 {
+				b.handle(batch)
+			}
+		}(b)
+	}
+	wg.Wait()
+}
+
+// HandleMessage adds the message to pending batch, the batch is handled by the worker adding its last message
+// so the worker stops receiving until the batch is handled
+func (b *batcher) HandleMessage(message *nsq.Message) error {
+	message.DisableAutoResponse()
+
+	b.mx.Lock()
+	b.pending = append(b.pending, message)
+	if len(b.pending) == 1 {
+		batchID := b.batchID
+		b.timer = time.AfterFunc(b.wait, func() {
+			b.flush(batchID)
+		})
+	}
+
+	var batch []*nsq.Message
+	if len(b.pending) >= b.size {
+		batch = b.take()
+	}
+	b.mx.Unlock()
+
+	if len(batch) > 0 {
+		b.handle(batch)
+	}
+	return nil
+}
+
+// LogFailedMessage moves message exceeding max attempts to dead letter topic
+func (b *batcher) LogFailedMessage(message *nsq.Message) {
+	b.result.LogFailedMessage(message)
+}
+
+// flush handles pending batch after BatchWaitMS
+func (b *batcher) flush(batchID uint64) {
+	b.mx.Lock()
+	var batch []*nsq.Message
+	if batchID == b.batchID {
+		batch = b.take()
+	}
+	b.mx.Unlock()
+
+	if len(batch) > 0 {
+		b.handle(batch)
+	}
+}
+
+// take return pending batch and starts a new one, caller must hold mx
+func (b *batcher) take() []*nsq.Message {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	batch := b.pending
+	b.pending = nil
+	b.batchID++
+	return batch
+}
+
+func (b *batcher) handle(batch []*nsq.Message) {
+	atomic.AddInt64(&inFlight, int64(len(batch)))
+	defer atomic.AddInt64(&inFlight, -int64(len(batch)))
+
+	ctx, cancel := context.WithTimeout(handlerCtx, b.timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go heartbeat(b.interval, done, batch...)
+	errs := b.call(ctx, batch)
+	close(done)
+
+	for i, message := range batch {
+		if i < len(errs) && errs[i] != nil {
+			b.results.Store(message, errs[i])
+		}
+
+		err := b.result.HandleMessage(message)
+		b.results.Delete(message)
+
+		// respond the same way the backend does when auto response is enabled
+		if message.HasResponded() {
+			continue
+		}
+		if err != nil {
+			message.Requeue(-1)
+			continue
+		}
+		message.Finish()
+	}
+}
+
+// call calls HandleBatch, panic fails every message of the batch
+func (b *batcher) call(ctx context.Context, batch []*nsq.Message) (errs []error) {
+	var err error
+	func() {
+		defer nsqPanicHandler(b.value.Topic, b.value.Channel, nil, &err)
+		errs = b.handler.HandleBatch(ctx, batch)
+	}()
+
+	if err != nil {
+		errs = make([]error, len(batch))
+		for i := range errs {
+			errs[i] = errors.Wrapf(err, "[Consumer][Batch] batch of %d messages", len(batch))
+		}
+	}
+	return errs
+}
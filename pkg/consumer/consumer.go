@@ -1,36 +1,132 @@
 package consumer
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
 	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bitly/go-nsq"
+	"github.com/pkg/errors"
 
-	"github.com/golang-base-template/pkg/consumer/ping"
 	"github.com/golang-base-template/util/config"
+	"github.com/golang-base-template/util/stream"
 )
 
-// Consumer Identifier
-const (
-	pingIdentifier = "ping-consumer"
+type (
+	// HandlerFactory creates handler of a worker from its ConsumerList config
+	HandlerFactory func(cfg *config.ConsumerListConfig) nsq.Handler
+
+	// runningConsumer is a started nsq or redis stream consumer worker
+	runningConsumer struct {
+		name     string
+		stop     func()
+		stopChan chan int
+	}
+)
+
+var (
+	factories = map[string]HandlerFactory{}
+	mxFactory sync.Mutex
+
+	running   []runningConsumer
+	mxRunning sync.Mutex
+	// inFlight is number of messages being handled
+	inFlight int64
 )
 
-func Init(cfg *config.Config) {
-	//Init Consumer configurations
-	consumerCfg := nsq.NewConfig()
+// Register registers handler factory of ConsumerList entry name, call it from init of the module package
+// and import the package from the main package. It panics if the name is registered twice.
+func Register(name string, factory HandlerFactory) {
+	mxFactory.Lock()
+	defer mxFactory.Unlock()
+
+	if factory == nil {
+		panic("[Consumer] Register factory of " + name + " is nil")
+	}
+	if _, exist := factories[name]; exist {
+		panic("[Consumer] Register is called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// validate checks every switched on ConsumerList entry has registered handler and middlewares,
+// and every registered handler has ConsumerList entry
+func validate(cfg *config.Config) error {
+	mxFactory.Lock()
+	defer mxFactory.Unlock()
+
+	var invalid []string
+	for key, value := range cfg.ConsumerList {
+		if _, ok := factories[key]; !ok && value.Switch {
+			invalid = append(invalid, key+" has no registered handler")
+		}
+	}
+	for name := range factories {
+		if _, ok := cfg.ConsumerList[name]; !ok {
+			invalid = append(invalid, name+" has no ConsumerList config")
+		}
+	}
+
+	mxMiddleware.Lock()
+	for key, value := range cfg.ConsumerList {
+		for _, name := range middlewareNames(value) {
+			if _, ok := middlewares[name]; !ok && value.Switch {
+				invalid = append(invalid, key+" has unregistered middleware "+name)
+			}
+		}
+	}
+	mxMiddleware.Unlock()
 
-	consumerCfg.MaxAttempts = cfg.Consumer.DefaultMaxAttempts
-	consumerCfg.MaxBackoffDuration = time.Duration(cfg.Consumer.MaxBackoffDuration) * time.Second
-	consumerCfg.DefaultRequeueDelay = time.Duration(cfg.Consumer.DefaultRequeueDelay) * time.Second
+	// middlewares are not applied per message of a batch, so they would be silently skipped
+	for key, value := range cfg.ConsumerList {
+		if value.BatchSize > 0 && value.Switch &&
+			(len(value.Middlewares) > 0 || value.Idempotency != nil || value.RateLimit != "") {
+			invalid = append(invalid, key+" has BatchSize with Middlewares, Idempotency or RateLimit")
+		}
+	}
+
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return errors.Errorf("[Consumer] invalid consumer: %s", strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// Init starts every switched on consumer of ConsumerList using its registered handler.
+// It fails fast if the ConsumerList and the registered handlers do not match.
+func Init(cfg *config.Config) error {
+	if err := validate(cfg); err != nil {
+		return err
+	}
 
 	for key, value := range cfg.ConsumerList {
 		if value.Config == nil {
+			//Init Consumer configurations, each consumer has its own max attempts and max in flight
+			consumerCfg := nsq.NewConfig()
+
+			consumerCfg.MaxAttempts = maxAttempts(cfg, value)
+			consumerCfg.MaxBackoffDuration = time.Duration(cfg.Consumer.MaxBackoffDuration) * time.Second
+			consumerCfg.DefaultRequeueDelay = time.Duration(cfg.Consumer.DefaultRequeueDelay) * time.Second
+			if value.MsgTimeoutSec > 0 {
+				consumerCfg.MsgTimeout = time.Duration(value.MsgTimeoutSec) * time.Second
+			}
+
 			if value.MaxInFlight != 0 {
 				consumerCfg.MaxInFlight = value.MaxInFlight
 			} else {
 				consumerCfg.MaxInFlight = cfg.Consumer.DefaultMaxInflight
 			}
+			// batch is never full if less messages are in flight
+			if consumerCfg.MaxInFlight < value.BatchSize {
+				consumerCfg.MaxInFlight = value.BatchSize
+			}
 			value.Config = consumerCfg
 		}
 
@@ -38,21 +134,42 @@ func Init(cfg *config.Config) {
 			continue
 		}
 
+		mxFactory.Lock()
+		factory := factories[key]
+		mxFactory.Unlock()
+
+		// middlewares are shared by workers of the consumer, e.g. rate limit quota
+		chains, err := buildMiddlewares(value)
+		if err != nil {
+			return err
+		}
+		// panic recovery, message deadline and heartbeat cover the whole chain regardless of Middlewares config
+		chains = append([]Middleware{Recover(value), Timeout(value)}, chains...)
+
 		for i := 0; i < value.WorkerAmount; i++ {
+			var handler nsq.Handler
+			if value.BatchSize > 0 {
+				batchHandler, ok := factory(value).(BatchHandler)
+				if !ok {
+					return errors.Errorf("[Consumer] %s has BatchSize but its handler is not BatchHandler", key)
+				}
+				handler = Batch(batchHandler, cfg, value)
+			} else {
+				handler = DeadLetter(trackInFlight(Chain(factory(value), chains...)), cfg, value)
+			}
+
+			if value.Backend == stream.Backend {
+				startStreamConsumer(cfg, key, i, value, handler)
+				continue
+			}
+
 			consumer, err := nsq.NewConsumer(value.Topic, value.Channel, value.Config)
 			if err != nil {
 				log.Printf("[Consumer] error when creating consumer for %s, err : %s\n", key, err.Error())
+				continue
 			}
-
-			switch key {
-			case pingIdentifier:
-				consumer.AddHandler(GuardConsumer(ping.PingHandler{
-					Topic:   value.Topic,
-					Channel: value.Channel,
-				}, value.Topic, value.Channel))
-			default:
-				log.Printf("[Consumer] fail to consume from topic: %s", value.Topic)
-			}
+			consumer.AddHandler(handler)
+			track(fmt.Sprintf("%s #%d", key, i+1), consumer.Stop, consumer.StopChan)
 
 			err = consumer.ConnectToNSQLookupds(cfg.Consumer.LookupdAddress)
 			if err != nil {
@@ -62,19 +179,108 @@ func Init(cfg *config.Config) {
 			}
 		}
 	}
+
+	return nil
+}
+
+// startStreamConsumer consumes redis stream of the consumer config with the same handler as nsq
+func startStreamConsumer(cfg *config.Config, key string, worker int, value *config.ConsumerListConfig, handler nsq.Handler) {
+	count := value.MaxInFlight
+	if count == 0 {
+		count = cfg.Consumer.DefaultMaxInflight
+	}
+	maxDeliveries := maxAttempts(cfg, value)
+
+	// consumer name is unique per pod and worker so pending message of crashed pod can be reclaimed by others
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%s-%s-%d", hostname, key, worker+1)
+
+	consumer, err := stream.NewConsumer(value.Redis, value.Topic, value.Channel, name, stream.Config{
+		Count:         int64(count),
+		MaxDeliveries: int64(maxDeliveries),
+		ClaimMinIdle:  time.Duration(value.ClaimMinIdleSec) * time.Second,
+		DeadLetter:    value.DeadLetter,
+	})
+	if err != nil {
+		log.Printf("[Consumer] error when creating redis stream consumer for %s, err : %s\n", key, err.Error())
+		return
+	}
+	consumer.AddHandler(handler)
+
+	err = consumer.Start()
+	if err != nil {
+		log.Printf("[Consumer] err starting redis stream consumer %s #%d, err : %s", key, worker+1, err.Error())
+		return
+	}
+	track(fmt.Sprintf("%s #%d", key, worker+1), consumer.Stop, consumer.StopChan)
+	log.Printf("[Consumer] %s #%d is listening on redis stream", key, worker+1)
+}
+
+// track registers started consumer so it is stopped by Stop
+func track(name string, stop func(), stopChan chan int) {
+	mxRunning.Lock()
+	defer mxRunning.Unlock()
+
+	running = append(running, runningConsumer{
+		name:     name,
+		stop:     stop,
+		stopChan: stopChan,
+	})
+}
+
+// Stop stops every started consumer from receiving new message, cancels message context of running handlers
+// and waits until their in-flight messages are handled, up to ctx deadline.
+// Message still in flight on deadline is redelivered later by the backend.
+func Stop(ctx context.Context) error {
+	mxRunning.Lock()
+	consumers := running
+	running = nil
+	mxRunning.Unlock()
+
+	for _, c := range consumers {
+		c.stop()
+	}
+	// pending batches are handled now instead of after BatchWaitMS, so their messages are responded before cancel
+	go flushBatches()
+
+	// running handlers finish their messages until ctx is done, then they are told to give up
+	// and their messages are delivered again later
+	var pending []string
+	for _, c := range consumers {
+		select {
+		case <-c.stopChan:
+		case <-ctx.Done():
+			cancelHandlers()
+			pending = append(pending, c.name)
+		}
+	}
+
+	if len(pending) > 0 {
+		return errors.Errorf("[Consumer][Stop] %d messages are still in flight on %s", atomic.LoadInt64(&inFlight), strings.Join(pending, ", "))
+	}
+	log.Printf("[Consumer] %d consumers are stopped", len(consumers))
+	return nil
 }
 
-// GuardConsumer protect consumer when it gets panic and then eventually recover
+// GuardConsumer protect consumer when it gets panic and then eventually recover,
+// the panic is returned as error so the message is retried instead of finished
 func GuardConsumer(fn nsq.Handler, topic, channel string) nsq.Handler {
+	return Chain(fn, Recover(&config.ConsumerListConfig{Topic: topic, Channel: channel}))
+}
+
+// trackInFlight counts messages being handled, the count is reported by Stop
+func trackInFlight(fn nsq.Handler) nsq.Handler {
 	return nsq.HandlerFunc(func(message *nsq.Message) error {
-		defer nsqPanicHandler(topic, channel, message.Body)
+		atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
 		return fn.HandleMessage(message)
 	})
 }
 
-func nsqPanicHandler(topic, channel string, message []byte) {
+func nsqPanicHandler(topic, channel string, message []byte, err *error) {
 	if r := recover(); r != nil {
 		log.Printf("[PANIC] %v topic : %s, channel : %s", r, topic, channel)
 		log.Printf("%s", string(debug.Stack()))
+		*err = errors.Errorf("panic: %v", r)
 	}
 }
@@ -0,0 +1,103 @@
+package consumer
+
+import (
+	"context"
+	"time"
+
+	"github.com/bitly/go-nsq"
+
+	"github.com/golang-base-template/util/config"
+	"github.com/golang-base-template/util/stream"
+)
+
+// defaultMsgTimeout is nsqd default msg timeout and default redis-stream ClaimMinIdle
+const defaultMsgTimeout = time.Minute
+
+type (
+	// ContextHandler handles message with its context. The context is cancelled when consumers are stopped
+	// and has deadline of Timeout.
+	ContextHandler interface {
+		HandleMessageContext(ctx context.Context, message *nsq.Message) error
+	}
+
+	// ContextHandlerFunc is ContextHandler func, it also implements nsq.Handler so it can be returned by HandlerFactory
+	ContextHandlerFunc func(ctx context.Context, message *nsq.Message) error
+)
+
+var (
+	// handlerCtx is parent of every message context, it is cancelled by Stop
+	handlerCtx, cancelHandlers = context.WithCancel(context.Background())
+)
+
+// HandleMessage handles message with context cancelled when consumers are stopped
+func (f ContextHandlerFunc) HandleMessage(message *nsq.Message) error {
+	return f(handlerCtx, message)
+}
+
+func (f ContextHandlerFunc) HandleMessageContext(ctx context.Context, message *nsq.Message) error {
+	return f(ctx, message)
+}
+
+// msgTimeout return how long the message may be handled before it is delivered again,
+// nsq msg timeout or redis-stream ClaimMinIdle
+func msgTimeout(value *config.ConsumerListConfig) time.Duration {
+	if value.Backend == stream.Backend {
+		if value.ClaimMinIdleSec > 0 {
+			return time.Duration(value.ClaimMinIdleSec) * time.Second
+		}
+		return defaultMsgTimeout
+	}
+
+	if value.MsgTimeoutSec > 0 {
+		return time.Duration(value.MsgTimeoutSec) * time.Second
+	}
+	return defaultMsgTimeout
+}
+
+// handlerTimeout return deadline of message context, HandlerTimeoutSec or msg timeout
+func handlerTimeout(value *config.ConsumerListConfig) time.Duration {
+	if value.HandlerTimeoutSec > 0 {
+		return time.Duration(value.HandlerTimeoutSec) * time.Second
+	}
+	return msgTimeout(value)
+}
+
+// Timeout sets HandlerTimeoutSec deadline, default to msg timeout, to message context. The message is touched
+// every half msg timeout while the next handler is running, so it is not delivered to other worker
+// even if the handler takes longer than msg timeout. Init applies it outside middleware chain of every consumer.
+func Timeout(value *config.ConsumerListConfig) Middleware {
+	timeout := handlerTimeout(value)
+	interval := msgTimeout(value) / 2
+
+	return func(next ContextHandler) ContextHandler {
+		return ContextHandlerFunc(func(ctx context.Context, message *nsq.Message) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			done := make(chan struct{})
+			defer close(done)
+			go heartbeat(interval, done, message)
+
+			return next.HandleMessageContext(ctx, message)
+		})
+	}
+}
+
+// heartbeat touches the messages every interval until done is closed, responded message is not touched
+func heartbeat(interval time.Duration, done chan struct{}, messages ...*nsq.Message) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			for _, message := range messages {
+				if !message.HasResponded() {
+					message.Touch()
+				}
+			}
+		}
+	}
+}
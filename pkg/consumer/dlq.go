@@ -0,0 +1,378 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitly/go-nsq"
+	"github.com/pkg/errors"
+
+	"github.com/golang-base-template/util/config"
+	nsqpublisher "github.com/golang-base-template/util/nsq"
+	"github.com/golang-base-template/util/stream"
+)
+
+const (
+	// dlqSuffix is appended to the topic for default nsq dead letter topic
+	dlqSuffix = ".dlq"
+	// dlqChannel keeps nsq dead letter messages until they are replayed
+	dlqChannel = "dlq"
+	// dlqIdleTimeout ends inspect and replay of nsq dead letter topic once no more message is received
+	dlqIdleTimeout = 3 * time.Second
+)
+
+type (
+	// DeadLetterMessage is a failed message with where it came from and why it failed.
+	// It is published as json to nsq dead letter topic, redis-stream keeps it as dead letter stream entry.
+	DeadLetterMessage struct {
+		ID       string `json:"id"`
+		Topic    string `json:"topic"`
+		Channel  string `json:"channel"`
+		Body     []byte `json:"body"`
+		Error    string `json:"error"`
+		Attempts uint16 `json:"attempts"`
+		// FailedAt is when the message was dead lettered in unix nano
+		FailedAt int64 `json:"failed_at"`
+	}
+
+	// permanentError marks handler error which never succeeds on retry, e.g. malformed message
+	permanentError struct {
+		err error
+	}
+
+	// deadLetterHandler dead letters the message on permanent error or on its last attempt
+	deadLetterHandler struct {
+		handler     nsq.Handler
+		value       *config.ConsumerListConfig
+		dlq         string
+		maxAttempts uint16
+		// idempotency reads state of the message exceeding max attempts, it is nil without Idempotency config
+		idempotency *idempotentHandler
+	}
+)
+
+// Permanent marks handler error as permanent, the message is dead lettered right away instead of being retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err is marked by Permanent or implements Permanent() bool returning true,
+// e.g. invalid envelope of nsq TypedHandler, err may be wrapped
+func IsPermanent(err error) bool {
+	var p interface{ Permanent() bool }
+	return errors.As(err, &p) && p.Permanent()
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func (e *permanentError) Permanent() bool {
+	return true
+}
+
+// DLQTopic return dead letter topic of the consumer config. It defaults to <Topic>.dlq on nsq
+// and to DeadLetter stream on redis-stream, which also keeps messages exceeding MaxDeliveries.
+func DLQTopic(value *config.ConsumerListConfig) string {
+	if value.Backend == stream.Backend {
+		if value.DeadLetter != "" {
+			return value.DeadLetter
+		}
+		return stream.DeadLetterStream(value.Topic)
+	}
+
+	if value.DLQ != "" {
+		return value.DLQ
+	}
+	return value.Topic + dlqSuffix
+}
+
+// DeadLetter wraps handler to apply retry policy and errors of the consumer config: error marked by Skip
+// finishes the message, error marked by Retry or other error requeues it with its delay, and
+// error marked by Permanent or error on the last attempt moves it to dead letter topic
+// instead of being dropped by the backend
+func DeadLetter(handler nsq.Handler, cfg *config.Config, value *config.ConsumerListConfig) nsq.Handler {
+	h := &deadLetterHandler{
+		handler:     handler,
+		value:       value,
+		dlq:         DLQTopic(value),
+		maxAttempts: maxAttempts(cfg, value),
+	}
+	if value.Idempotency != nil {
+		h.idempotency = newIdempotentHandler(value)
+	}
+	return h
+}
+
+func (h *deadLetterHandler) HandleMessage(message *nsq.Message) error {
+	err := h.handler.HandleMessage(message)
+	if err == nil || message.HasResponded() {
+		return err
+	}
+
+	if IsSkip(err) {
+		log.Printf("[Consumer][Skip] message of %s is skipped, err: %v", h.value.Topic, err)
+		message.Finish()
+		return nil
+	}
+
+	// handler given up on Stop or message in progress by other worker did not fail,
+	// the message is delivered again without counting toward dead letter
+	if handlerCtx.Err() != nil && !IsPermanent(err) {
+		message.RequeueWithoutBackoff(0)
+		return err
+	}
+	if errors.Is(err, ErrInProgress) {
+		delay, _ := retryAfter(err)
+		message.RequeueWithoutBackoff(delay)
+		return err
+	}
+
+	if IsPermanent(err) || (h.maxAttempts > 0 && message.Attempts >= h.maxAttempts) {
+		errDLQ := h.deadLetter(message, err)
+		if errDLQ == nil {
+			message.Finish()
+			return nil
+		}
+		// keep the message so it is retried rather than lost
+		log.Printf("[Consumer][DeadLetter] fail to move message of %s to %s, err: %v", h.value.Topic, h.dlq, errDLQ)
+	}
+
+	delay, ok := retryAfter(err)
+	if !ok {
+		delay = retryDelay(h.value.Retry, message.Attempts)
+	}
+	if delay >= 0 {
+		message.RequeueWithoutBackoff(delay)
+	}
+	return err
+}
+
+// LogFailedMessage is called by nsq consumer when message exceeds max attempts before reaching the handler.
+// Attempts also grow while duplicate waits for other worker holding its idempotency lock, such message
+// is requeued while it is in progress and finished once it is done instead of being dead lettered.
+func (h *deadLetterHandler) LogFailedMessage(message *nsq.Message) {
+	if h.idempotency != nil {
+		state, err := h.idempotency.state(context.Background(), message)
+		switch {
+		case err != nil:
+			log.Printf("[Consumer][DeadLetter] fail to get idempotency state of message of %s, err: %v", h.value.Topic, err)
+		case state == processingPrefix:
+			message.RequeueWithoutBackoff(h.idempotency.retryDelay)
+			return
+		case state == stateDone:
+			log.Printf("[Consumer][DeadLetter] message of %s exceeding max attempts is already handled", h.value.Topic)
+			return
+		}
+	}
+
+	err := h.deadLetter(message, errors.New("max attempts exceeded"))
+	if err != nil {
+		log.Printf("[Consumer][DeadLetter] fail to move message of %s to %s, err: %v", h.value.Topic, h.dlq, err)
+	}
+}
+
+func (h *deadLetterHandler) deadLetter(message *nsq.Message, cause error) error {
+	var err error
+	if h.value.Backend == stream.Backend {
+		err = stream.AddDeadLetter(context.Background(), h.value.Redis, h.dlq, stream.DeadLetterEntry{
+			ID:         stream.EntryID(message),
+			Stream:     h.value.Topic,
+			Group:      h.value.Channel,
+			Body:       message.Body,
+			Deliveries: int64(message.Attempts),
+			Error:      cause.Error(),
+		})
+	} else {
+		var data []byte
+		data, err = json.Marshal(DeadLetterMessage{
+			ID:       string(message.ID[:]),
+			Topic:    h.value.Topic,
+			Channel:  h.value.Channel,
+			Body:     message.Body,
+			Error:    cause.Error(),
+			Attempts: message.Attempts,
+			FailedAt: time.Now().UnixNano(),
+		})
+		if err == nil {
+			err = nsqpublisher.SendNSQ(h.dlq, data)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[Consumer][DeadLetter] message of %s is moved to %s after %d attempts, err: %v", h.value.Topic, h.dlq, message.Attempts, cause)
+	return nil
+}
+
+// InspectDLQ calls fn for up to limit dead letter messages of the consumer, messages are kept in dead letter topic
+func InspectDLQ(ctx context.Context, cfg *config.Config, name string, limit int, fn func(DeadLetterMessage)) error {
+	return consumeDLQ(ctx, cfg, name, limit, func(dl DeadLetterMessage) (bool, error) {
+		fn(dl)
+		return false, nil
+	})
+}
+
+// ReplayDLQ publishes up to limit dead letter messages of the consumer back to their source topic
+// and removes them from dead letter topic, it return number of replayed messages
+func ReplayDLQ(ctx context.Context, cfg *config.Config, name string, limit int) (int, error) {
+	value, ok := cfg.ConsumerList[name]
+	if !ok {
+		return 0, errors.Errorf("[Consumer][ReplayDLQ] %s has no ConsumerList config", name)
+	}
+
+	var replayed int
+	err := consumeDLQ(ctx, cfg, name, limit, func(dl DeadLetterMessage) (bool, error) {
+		var err error
+		if value.Backend == stream.Backend {
+			_, err = stream.Publish(ctx, value.Redis, dl.Topic, dl.Body)
+		} else {
+			err = nsqpublisher.SendNSQ(dl.Topic, dl.Body)
+		}
+		if err != nil {
+			return false, err
+		}
+
+		replayed++
+		return true, nil
+	})
+	return replayed, err
+}
+
+// consumeDLQ passes up to limit dead letter messages to fn, the message is removed when fn return true
+func consumeDLQ(ctx context.Context, cfg *config.Config, name string, limit int, fn func(DeadLetterMessage) (bool, error)) error {
+	value, ok := cfg.ConsumerList[name]
+	if !ok {
+		return errors.Errorf("[Consumer][DLQ] %s has no ConsumerList config", name)
+	}
+	if limit <= 0 {
+		return errors.New("[Consumer][DLQ] limit must be positive")
+	}
+
+	if value.Backend == stream.Backend {
+		return consumeStreamDLQ(ctx, value, limit, fn)
+	}
+	return consumeNSQDLQ(ctx, cfg, value, limit, fn)
+}
+
+// consumeNSQDLQ holds up to limit messages of dlq channel, then finishes the removed ones and requeues the rest
+func consumeNSQDLQ(ctx context.Context, cfg *config.Config, value *config.ConsumerListConfig, limit int, fn func(DeadLetterMessage) (bool, error)) error {
+	nsqCfg := nsq.NewConfig()
+	nsqCfg.MaxInFlight = limit
+
+	consumer, err := nsq.NewConsumer(DLQTopic(value), dlqChannel, nsqCfg)
+	if err != nil {
+		return errors.Wrap(err, "[Consumer][DLQ] fail to create dlq consumer")
+	}
+
+	var (
+		mx       sync.Mutex
+		held     []*nsq.Message
+		done     bool
+		firstErr error
+		received = make(chan struct{}, limit)
+	)
+	consumer.AddHandler(nsq.HandlerFunc(func(message *nsq.Message) error {
+		message.DisableAutoResponse()
+
+		mx.Lock()
+		defer mx.Unlock()
+		if done || len(held) >= limit {
+			message.RequeueWithoutBackoff(0)
+			return nil
+		}
+
+		var dl DeadLetterMessage
+		remove := false
+		if err := json.Unmarshal(message.Body, &dl); err != nil {
+			log.Printf("[Consumer][DLQ] skip malformed dead letter message %s, err: %v", message.ID, err)
+		} else if remove, err = fn(dl); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if remove {
+			message.Finish()
+		} else {
+			held = append(held, message)
+		}
+
+		received <- struct{}{}
+		return nil
+	}))
+
+	err = consumer.ConnectToNSQLookupds(cfg.Consumer.LookupdAddress)
+	if err != nil {
+		return errors.Wrap(err, "[Consumer][DLQ] fail to connect to lookupd")
+	}
+
+	for count := 0; count < limit; count++ {
+		select {
+		case <-received:
+			continue
+		case <-time.After(dlqIdleTimeout):
+		case <-ctx.Done():
+		}
+		break
+	}
+
+	// kept messages are held until the end so the same message is not received twice
+	mx.Lock()
+	done = true
+	for _, message := range held {
+		message.RequeueWithoutBackoff(0)
+	}
+	mx.Unlock()
+
+	consumer.Stop()
+	<-consumer.StopChan
+	return firstErr
+}
+
+// consumeStreamDLQ reads up to limit oldest entries of dead letter stream
+func consumeStreamDLQ(ctx context.Context, value *config.ConsumerListConfig, limit int, fn func(DeadLetterMessage) (bool, error)) error {
+	dlq := DLQTopic(value)
+	entries, err := stream.ReadDeadLetter(ctx, value.Redis, dlq, int64(limit))
+	if err != nil {
+		return errors.Wrap(err, "[Consumer][DLQ]")
+	}
+
+	for _, entry := range entries {
+		dl := DeadLetterMessage{
+			ID:       entry.ID,
+			Topic:    entry.Stream,
+			Channel:  entry.Group,
+			Body:     entry.Body,
+			Error:    entry.Error,
+			Attempts: uint16(entry.Deliveries),
+		}
+		if ms, err := strconv.ParseInt(strings.SplitN(entry.EntryID, "-", 2)[0], 10, 64); err == nil {
+			dl.FailedAt = ms * int64(time.Millisecond)
+		}
+		if dl.Topic == "" {
+			dl.Topic = value.Topic
+		}
+
+		remove, err := fn(dl)
+		if err != nil {
+			return err
+		}
+		if remove {
+			if err = stream.RemoveDeadLetter(ctx, value.Redis, dlq, entry.EntryID); err != nil {
+				return errors.Wrap(err, "[Consumer][DLQ]")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bitly/go-nsq"
+	"github.com/pkg/errors"
+
+	"github.com/golang-base-template/util/cache"
+	"github.com/golang-base-template/util/config"
+	"github.com/golang-base-template/util/stream"
+)
+
+const (
+	// defaultIdempotencyTTL is how long completed message is remembered
+	defaultIdempotencyTTL = 24 * time.Hour
+
+	// processing state is stored as processingPrefix + owner token of the delivery, done state as stateDone
+	processingPrefix = "processing:"
+	stateDone        = "done"
+)
+
+type (
+	// idempotentHandler records processing state of the message key in redis,
+	// so the message redelivered after it is handled is skipped
+	idempotentHandler struct {
+		next     ContextHandler
+		value    *config.ConsumerListConfig
+		cache    cache.ICache
+		keyField []string
+		ttl      time.Duration
+		lockTTL  time.Duration
+		// retryDelay is requeue delay of duplicate in progress
+		retryDelay time.Duration
+	}
+)
+
+var (
+	// ErrInProgress is returned when the message is being handled by other worker, it is retried until the lock
+	// is released or expires and does not count toward dead letter
+	ErrInProgress = errors.New("consumer: message is in progress")
+
+	idempotencyKey = cache.MustRegisterKey("gbt:consumer:idempotency:{topic}:{channel}:{id}", defaultIdempotencyTTL, nil)
+)
+
+// Idempotency handles message with the same key once: the key is locked as processing while the next handler
+// runs, then marked done for Idempotency TTLSec. Completed duplicate is finished, duplicate in progress by other
+// worker is requeued until the lock is released or expires. Handler error releases the lock so the message
+// can be retried. It does nothing if Idempotency of the consumer config is not set.
+func Idempotency(value *config.ConsumerListConfig) Middleware {
+	return func(next ContextHandler) ContextHandler {
+		if value.Idempotency == nil {
+			return next
+		}
+
+		h := newIdempotentHandler(value)
+		h.next = next
+		return ContextHandlerFunc(h.handle)
+	}
+}
+
+// newIdempotentHandler return idempotentHandler of the consumer config without next handler,
+// Idempotency of the config must be set
+func newIdempotentHandler(value *config.ConsumerListConfig) *idempotentHandler {
+	h := &idempotentHandler{
+		value: value,
+		cache: cache.NewCache(),
+		ttl:   idempotencyKey.TTL,
+		// lock outlives message context so it is not taken while the handler is still running
+		lockTTL:    handlerTimeout(value) + msgTimeout(value),
+		retryDelay: msgTimeout(value) / 2,
+	}
+	if value.Idempotency.Redis != "" {
+		h.cache = cache.NewCache(value.Idempotency.Redis)
+	}
+	if value.Idempotency.KeyField != "" {
+		h.keyField = strings.Split(value.Idempotency.KeyField, ".")
+	}
+	if value.Idempotency.TTLSec > 0 {
+		h.ttl = time.Duration(value.Idempotency.TTLSec) * time.Second
+	}
+	return h
+}
+
+func (h *idempotentHandler) handle(msgCtx context.Context, message *nsq.Message) error {
+	// state is recorded even after message context is cancelled
+	ctx := context.Background()
+
+	key, err := h.key(message)
+	if err != nil {
+		return Permanent(errors.Wrap(err, "[Consumer][Idempotent]"))
+	}
+
+	// every delivery has its own owner, lock left by crashed worker is taken after lockTTL
+	owner := processingPrefix + newOwner()
+	locked, err := h.cache.SetNX(ctx, key.String(), owner, h.lockTTL)
+	if err != nil {
+		return errors.Wrap(err, "[Consumer][Idempotent] fail to lock message")
+	}
+
+	if !locked {
+		state, err := h.cache.Get(ctx, key.String())
+		if err == cache.ErrCacheMiss {
+			// released right after SetNX, check again on next delivery
+			return Retry(ErrInProgress, h.retryDelay)
+		}
+		if err != nil {
+			return errors.Wrap(err, "[Consumer][Idempotent] fail to get message state")
+		}
+
+		if state == stateDone {
+			log.Printf("[Consumer][Idempotent] message %s of %s is already handled", key, h.value.Topic)
+			message.Finish()
+			return nil
+		}
+		return Retry(ErrInProgress, h.retryDelay)
+	}
+
+	// release the lock on error or panic, so the message can be retried
+	done := false
+	defer func() {
+		if done {
+			return
+		}
+		if _, errDel := h.cache.EvalScript(ctx, cache.ScriptCompareAndDelete, []string{key.String()}, owner); errDel != nil {
+			log.Printf("[Consumer][Idempotent] fail to release %s, err: %v", key, errDel)
+		}
+	}()
+
+	err = h.next.HandleMessageContext(msgCtx, message)
+	if err != nil {
+		return err
+	}
+
+	done = true
+	_, errSet := h.cache.EvalScript(ctx, cache.ScriptCompareAndSet, []string{key.String()}, owner, stateDone, h.ttl.Milliseconds())
+	if errSet != nil {
+		// the message is handled, its duplicate is only deduplicated while the lock lives
+		log.Printf("[Consumer][Idempotent] fail to mark %s done, err: %v", key, errSet)
+	}
+	return nil
+}
+
+// key return idempotency key of the message
+func (h *idempotentHandler) key(message *nsq.Message) (cache.Key, error) {
+	// key field value may contain key separator, e.g. "order:123"
+	return idempotencyKey.Build(h.value.Topic, h.value.Channel, url.QueryEscape(h.messageKey(message)))
+}
+
+// state return recorded state of the message, it is "" if the message is neither in progress nor done
+func (h *idempotentHandler) state(ctx context.Context, message *nsq.Message) (string, error) {
+	key, err := h.key(message)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := h.cache.Get(ctx, key.String())
+	if errors.Cause(err) == cache.ErrCacheMiss {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(state, processingPrefix) {
+		return processingPrefix, nil
+	}
+	return state, nil
+}
+
+// messageKey return value of KeyField in json body, or message ID if KeyField is not set or not found
+func (h *idempotentHandler) messageKey(message *nsq.Message) string {
+	if len(h.keyField) == 0 {
+		return stream.EntryID(message)
+	}
+
+	// number is kept as json.Number, so large integer id is not formatted as float (e.g. 1e+21)
+	var body map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(message.Body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err == nil {
+		var v interface{} = body
+		for _, field := range h.keyField {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				v = nil
+				break
+			}
+			v = m[field]
+		}
+		if v != nil {
+			if s, ok := v.(string); ok {
+				return s
+			}
+			data, _ := json.Marshal(v)
+			return string(data)
+		}
+	}
+
+	log.Printf("[Consumer][Idempotent] %s is not found in message of %s, message ID is used", h.value.Idempotency.KeyField, h.value.Topic)
+	return stream.EntryID(message)
+}
+
+// newOwner return random token of the lock
+func newOwner() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,213 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bitly/go-nsq"
+	"github.com/pkg/errors"
+
+	"github.com/golang-base-template/util/config"
+	"github.com/golang-base-template/util/metrics"
+	nsqpublisher "github.com/golang-base-template/util/nsq"
+	"github.com/golang-base-template/util/ratelimit"
+	"github.com/golang-base-template/util/stream"
+)
+
+// name of built-in middlewares used in ConsumerList Middlewares
+const (
+	MiddlewareLogging     = "logging"
+	MiddlewareMetrics     = "metrics"
+	MiddlewareTracing     = "tracing"
+	MiddlewareIdempotency = "idempotency"
+	MiddlewareRateLimit   = "ratelimit"
+
+	// MiddlewareRecover and MiddlewareTimeout are accepted for existing config but do nothing,
+	// Recover and Timeout are always applied outside the middleware chain
+	MiddlewareRecover = "recover"
+	MiddlewareTimeout = "timeout"
+)
+
+type (
+	// Middleware wraps consumer handler, like middleware.Chain of http handler
+	Middleware func(next ContextHandler) ContextHandler
+
+	// MiddlewareFactory creates middleware of a consumer from its ConsumerList config
+	MiddlewareFactory func(value *config.ConsumerListConfig) Middleware
+)
+
+var (
+	// DefaultMiddlewares is middleware chain of consumer without Middlewares config
+	DefaultMiddlewares = []string{MiddlewareIdempotency}
+
+	// ErrRateLimited is returned when message exceeds rate limit of the consumer, it is retried after the limit resets
+	ErrRateLimited = errors.New("consumer: rate limit exceeded")
+
+	middlewares = map[string]MiddlewareFactory{
+		MiddlewareRecover:     noop,
+		MiddlewareLogging:     Logging,
+		MiddlewareMetrics:     Metrics,
+		MiddlewareTracing:     Tracing,
+		MiddlewareTimeout:     noop,
+		MiddlewareIdempotency: Idempotency,
+		MiddlewareRateLimit:   RateLimit,
+	}
+	mxMiddleware sync.Mutex
+)
+
+// Chain wraps endHandler with middlewares, the first middleware is the outermost
+func Chain(endHandler nsq.Handler, chains ...Middleware) nsq.Handler {
+	h := contextHandlerOf(endHandler)
+	for i := len(chains) - 1; i >= 0; i-- {
+		h = chains[i](h)
+	}
+	return ContextHandlerFunc(h.HandleMessageContext)
+}
+
+// RegisterMiddleware registers middleware factory by name so it can be used in ConsumerList Middlewares,
+// call it from init of the module package. It panics if the name is registered twice.
+func RegisterMiddleware(name string, factory MiddlewareFactory) {
+	mxMiddleware.Lock()
+	defer mxMiddleware.Unlock()
+
+	if factory == nil {
+		panic("[Consumer] RegisterMiddleware factory of " + name + " is nil")
+	}
+	if _, exist := middlewares[name]; exist {
+		panic("[Consumer] RegisterMiddleware is called twice for " + name)
+	}
+	middlewares[name] = factory
+}
+
+// middlewareNames return Middlewares of the consumer config or DefaultMiddlewares
+func middlewareNames(value *config.ConsumerListConfig) []string {
+	if len(value.Middlewares) > 0 {
+		return value.Middlewares
+	}
+	return DefaultMiddlewares
+}
+
+// buildMiddlewares creates middleware chain of the consumer config
+func buildMiddlewares(value *config.ConsumerListConfig) ([]Middleware, error) {
+	mxMiddleware.Lock()
+	defer mxMiddleware.Unlock()
+
+	names := middlewareNames(value)
+	chains := make([]Middleware, 0, len(names))
+	for _, name := range names {
+		factory, ok := middlewares[name]
+		if !ok {
+			return nil, errors.Errorf("[Consumer] middleware %s is not registered", name)
+		}
+		chains = append(chains, factory(value))
+	}
+	return chains, nil
+}
+
+// contextHandlerOf return ContextHandler of the handler, handler without HandleMessageContext ignores the context
+func contextHandlerOf(handler nsq.Handler) ContextHandler {
+	if ch, ok := handler.(ContextHandler); ok {
+		return ch
+	}
+	return ContextHandlerFunc(func(ctx context.Context, message *nsq.Message) error {
+		return handler.HandleMessage(message)
+	})
+}
+
+// noop passes the message to the next handler
+func noop(value *config.ConsumerListConfig) Middleware {
+	return func(next ContextHandler) ContextHandler {
+		return next
+	}
+}
+
+// Recover recovers panic of the next handler and returns it as error, so the message is requeued instead of finished
+func Recover(value *config.ConsumerListConfig) Middleware {
+	return func(next ContextHandler) ContextHandler {
+		return ContextHandlerFunc(func(ctx context.Context, message *nsq.Message) (err error) {
+			defer nsqPanicHandler(value.Topic, value.Channel, message.Body, &err)
+			return next.HandleMessageContext(ctx, message)
+		})
+	}
+}
+
+// Logging logs every handled message with its duration, trace id and error
+func Logging(value *config.ConsumerListConfig) Middleware {
+	return func(next ContextHandler) ContextHandler {
+		return ContextHandlerFunc(func(ctx context.Context, message *nsq.Message) error {
+			start := time.Now()
+			err := next.HandleMessageContext(ctx, message)
+			log.Printf("[Consumer] message %s of %s/%s attempt %d is handled in %v, trace: %s, err: %v",
+				stream.EntryID(message), value.Topic, value.Channel, message.Attempts, time.Since(start), nsqpublisher.TraceID(ctx), err)
+			return err
+		})
+	}
+}
+
+// Metrics records count, error count and latency of handled messages by topic and channel, see util/metrics
+func Metrics(value *config.ConsumerListConfig) Middleware {
+	return func(next ContextHandler) ContextHandler {
+		return ContextHandlerFunc(func(ctx context.Context, message *nsq.Message) error {
+			start := time.Now()
+			err := next.HandleMessageContext(ctx, message)
+
+			metrics.ObserveDuration("consumer.message.latency", time.Since(start), value.Topic, value.Channel)
+			metrics.IncrCounter("consumer.message.count", 1, value.Topic, value.Channel)
+			if err != nil {
+				metrics.IncrCounter("consumer.message.errors", 1, value.Topic, value.Channel)
+			}
+			return err
+		})
+	}
+}
+
+// Tracing sets trace id of the message to its context, it is trace id of nsq envelope or the message ID.
+// Envelope published with the context continues the trace.
+func Tracing(value *config.ConsumerListConfig) Middleware {
+	return func(next ContextHandler) ContextHandler {
+		return ContextHandlerFunc(func(ctx context.Context, message *nsq.Message) error {
+			if nsqpublisher.TraceID(ctx) == "" {
+				traceID := stream.EntryID(message)
+
+				var env nsqpublisher.Envelope
+				if err := json.Unmarshal(message.Body, &env); err == nil && env.TraceID != "" {
+					traceID = env.TraceID
+				}
+				ctx = nsqpublisher.WithTraceID(ctx, traceID)
+			}
+			return next.HandleMessageContext(ctx, message)
+		})
+	}
+}
+
+// RateLimit limits messages of the consumer using RateLimit config of its RateLimit rule, quota is shared
+// by every worker of the topic and channel. Message exceeding the limit is requeued after the limit resets.
+// Message is passed through if the rule is not configured or the limiter cannot decide.
+func RateLimit(value *config.ConsumerListConfig) Middleware {
+	limiter, err := ratelimit.NewLimiterFromConfig(value.RateLimit)
+	if err != nil {
+		log.Printf("[Consumer][RateLimit] rate limit of %s is disabled, err: %v", value.Topic, err)
+		return func(next ContextHandler) ContextHandler {
+			return next
+		}
+	}
+
+	key := value.Topic + ":" + value.Channel
+	return func(next ContextHandler) ContextHandler {
+		return ContextHandlerFunc(func(ctx context.Context, message *nsq.Message) error {
+			res, err := limiter.Allow(ctx, key)
+			if err != nil {
+				log.Printf("[Consumer][RateLimit] fail to check rate limit %s, err: %v", limiter.Rule().Name, err)
+				return next.HandleMessageContext(ctx, message)
+			}
+
+			if !res.Allowed {
+				return Retry(ErrRateLimited, res.RetryAfter)
+			}
+			return next.HandleMessageContext(ctx, message)
+		})
+	}
+}
@@ -6,8 +6,14 @@ import (
 
 	"github.com/bitly/go-nsq"
 	"github.com/pkg/errors"
+
+	"github.com/golang-base-template/pkg/consumer"
+	"github.com/golang-base-template/util/config"
 )
 
+// Identifier is ConsumerList entry name of ping consumer
+const Identifier = "ping-consumer"
+
 type (
 	//PingHandler contains topic and channel
 	PingHandler struct {
@@ -21,6 +27,15 @@ type (
 	}
 )
 
+func init() {
+	consumer.Register(Identifier, func(cfg *config.ConsumerListConfig) nsq.Handler {
+		return PingHandler{
+			Topic:   cfg.Topic,
+			Channel: cfg.Channel,
+		}
+	})
+}
+
 // HandleMessage to handle ping message
 func (p PingHandler) HandleMessage(message *nsq.Message) error {
 	data := Data{}
@@ -30,8 +45,8 @@ func (p PingHandler) HandleMessage(message *nsq.Message) error {
 	if err != nil {
 		err = errors.Wrapf(err, "[Consumer] error when unmarshalling: %v, err: ", message.Body)
 		log.Println(err.Error())
-		message.Finish()
-		return err
+		// malformed message never succeeds on retry
+		return consumer.Permanent(err)
 	}
 
 	log.Println("[Consumer] ", data)
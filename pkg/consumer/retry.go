@@ -0,0 +1,120 @@
+package consumer
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/golang-base-template/util/config"
+	"github.com/golang-base-template/util/stream"
+)
+
+// maxRetryDelay caps retry policy delay without MaxDelayMS, it is nsqd default max-req-timeout
+const maxRetryDelay = time.Hour
+
+type (
+	// retryError requeues the message after its delay, see Retry
+	retryError struct {
+		err   error
+		after time.Duration
+	}
+
+	// skipError finishes the message without retrying nor dead lettering it, see Skip
+	skipError struct {
+		err error
+	}
+)
+
+// Retry requeues the message after the delay instead of retry policy delay, e.g. on rate limit of external service.
+// The message is dead lettered if it is on its last attempt. Redis-stream does not honor the delay,
+// the message is delivered again after ClaimMinIdle.
+func Retry(err error, after time.Duration) error {
+	if err == nil {
+		err = errors.New("retry requested")
+	}
+	return &retryError{err: err, after: after}
+}
+
+// Skip finishes the message without retrying nor dead lettering it, e.g. outdated event
+func Skip(err error) error {
+	if err == nil {
+		err = errors.New("skip requested")
+	}
+	return &skipError{err: err}
+}
+
+// IsSkip reports whether err is marked by Skip, err may be wrapped
+func IsSkip(err error) bool {
+	var s *skipError
+	return errors.As(err, &s)
+}
+
+// retryAfter return delay of error marked by Retry
+func retryAfter(err error) (time.Duration, bool) {
+	var r *retryError
+	if errors.As(err, &r) {
+		return r.after, true
+	}
+	return 0, false
+}
+
+func (e *retryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryError) Unwrap() error {
+	return e.err
+}
+
+func (e *skipError) Error() string {
+	return e.err.Error()
+}
+
+func (e *skipError) Unwrap() error {
+	return e.err
+}
+
+// maxAttempts return number of attempts of the consumer before its message is dead lettered
+func maxAttempts(cfg *config.Config, value *config.ConsumerListConfig) uint16 {
+	if value.Backend == stream.Backend && value.MaxDeliveries > 0 {
+		return uint16(value.MaxDeliveries)
+	}
+	if value.Retry != nil && value.Retry.MaxAttempts > 0 {
+		return uint16(value.Retry.MaxAttempts)
+	}
+	return cfg.Consumer.DefaultMaxAttempts
+}
+
+// retryDelay return requeue delay of the attempt by retry policy,
+// negative delay lets the backend apply its default requeue delay and backoff
+func retryDelay(policy *config.RetryConfig, attempt uint16) time.Duration {
+	if policy == nil || policy.BaseDelayMS <= 0 {
+		return -1
+	}
+
+	var (
+		delay    = float64(time.Duration(policy.BaseDelayMS) * time.Millisecond)
+		maxDelay = float64(maxRetryDelay)
+	)
+	if policy.MaxDelayMS > 0 {
+		maxDelay = float64(time.Duration(policy.MaxDelayMS) * time.Millisecond)
+	}
+	if policy.Multiplier > 1 {
+		for i := uint16(1); i < attempt && delay < maxDelay; i++ {
+			delay *= policy.Multiplier
+		}
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	jitter := policy.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	if jitter > 0 {
+		delay -= delay * jitter * rand.Float64()
+	}
+	return time.Duration(delay)
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/golang-base-template/util/cache"
+	"github.com/golang-base-template/util/response"
+)
+
+type (
+	// CacheKeyTemplate is registered cache key template shown on admin endpoint
+	CacheKeyTemplate struct {
+		Template string   `json:"template"`
+		Pattern  string   `json:"pattern"`
+		Params   []string `json:"params"`
+		TTL      string   `json:"ttl"`
+		Codec    byte     `json:"codec"`
+	}
+
+	// CacheInvalidation is result of pattern invalidation
+	CacheInvalidation struct {
+		Pattern string `json:"pattern"`
+		Deleted int64  `json:"deleted"`
+	}
+)
+
+// ListCacheKeys list every registered cache key template for debugging
+func ListCacheKeys(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	res := response.New(r.Header.Get("Origin"), "true")
+
+	keys := cache.RegisteredKeys()
+	result := make([]CacheKeyTemplate, 0, len(keys))
+	for _, kt := range keys {
+		result = append(result, CacheKeyTemplate{
+			Template: kt.Template,
+			Pattern:  kt.Pattern(),
+			Params:   kt.Params(),
+			TTL:      kt.TTL.String(),
+			Codec:    kt.Codec.ID(),
+		})
+	}
+
+	res.WriteResponse(w, result)
+	return
+}
+
+// InvalidateCacheKeys unlinks every key of registered template (?template=) or matching pattern (?pattern=)
+func InvalidateCacheKeys(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	ctx := r.Context()
+	res := response.New(r.Header.Get("Origin"), "true")
+
+	pattern := r.URL.Query().Get("pattern")
+	if template := r.URL.Query().Get("template"); template != "" {
+		kt, ok := cache.GetKeyTemplate(template)
+		if !ok {
+			res.WriteError(w, http.StatusNotFound, []string{"cache key template is not registered"}, template)
+			return
+		}
+		pattern = kt.Pattern()
+	}
+	if pattern == "" {
+		res.WriteError(w, http.StatusBadRequest, []string{"template or pattern is required"}, "")
+		return
+	}
+
+	deleted, err := cache.InvalidatePattern(ctx, pattern)
+	if err != nil {
+		res.WriteError(w, http.StatusInternalServerError, []string{"error when invalidate cache keys"}, err.Error())
+		return
+	}
+
+	res.WriteResponse(w, CacheInvalidation{
+		Pattern: pattern,
+		Deleted: deleted,
+	})
+	return
+}
@@ -1,12 +1,47 @@
 package http
 
 import (
+	"expvar"
+	"net/http"
+	"time"
+
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/golang-base-template/util/config"
 	"github.com/golang-base-template/util/middleware"
 )
 
+const defaultRequestTimeout = 5 * time.Second
+
 func AssignRoutes(router *httprouter.Router) {
-	router.GET("/get-data/:source", middleware.ChainReq(GetData, middleware.InitContext, middleware.SetHeader))
-	router.POST("/create-gbt-employee", middleware.ChainReq(CreateGbtEmployee, middleware.InitContext, middleware.SetHeader, middleware.CSRF))
+	timeout := middleware.Timeout(requestTimeout())
+
+	router.GET("/get-data/:source", middleware.ChainReq(GetData, middleware.InitContext, timeout, middleware.SetHeader))
+	router.POST("/create-gbt-employee", middleware.ChainReq(CreateGbtEmployee, middleware.InitContext, timeout, middleware.SetHeader, middleware.CSRF, middleware.RateLimit("create-gbt-employee")))
+
+	// debug endpoints are only exposed when enabled by debug config
+	debug := config.Get().Debug
+	auth := middleware.DebugAuth(debug.Token)
+
+	// cache key registry for debugging and bulk invalidation
+	if debug.CacheKeys {
+		router.GET("/debug/cache/keys", middleware.ChainReq(ListCacheKeys, middleware.InitContext, timeout, auth, middleware.SetHeader))
+		router.DELETE("/debug/cache/keys", middleware.ChainReq(InvalidateCacheKeys, middleware.InitContext, timeout, auth, middleware.SetHeader))
+	}
+
+	// expvar metrics (e.g. cache metrics), it also exposes cmdline and memstats of the process
+	if debug.Vars {
+		vars := expvar.Handler()
+		router.GET("/debug/vars", middleware.ChainReq(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			vars.ServeHTTP(w, r)
+		}, auth))
+	}
+}
+
+// requestTimeout return deadline of request context from http config
+func requestTimeout() time.Duration {
+	if ms := config.Get().HTTP.RequestTimeoutMS; ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return defaultRequestTimeout
 }
@@ -2,56 +2,278 @@ package cache
 
 import (
 	"context"
-	"sync"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
 
 	"github.com/allegro/bigcache/v3"
 	"github.com/pkg/errors"
+
+	"github.com/golang-base-template/util/config"
 )
 
-type IBigCache interface {
-	Get(key string) ([]byte, error)
-	Set(key string, data []byte) error
-}
-type bigCache struct {
-	bigCache *bigcache.BigCache
-	mx       *sync.Mutex
-}
+// default local cache config, used when the config is not specified
+const (
+	defaultBigCacheShards             = 32
+	defaultBigCacheLifeWindowSec      = 60
+	defaultBigCacheMaxEntriesInWindow = 100000
+	defaultBigCacheMaxEntrySizeByte   = 512
+	defaultBigCacheHardMaxSizeMB      = 256
+)
 
-func NewBigCache() IBigCache {
-	// TODO: Set default config
+// reason of local cache entry removal passed to OnRemove callback
+const (
+	RemoveExpired = bigcache.Expired
+	RemoveNoSpace = bigcache.NoSpace
+	RemoveDeleted = bigcache.Deleted
+)
 
-	bc, err := bigcache.New(context.Background(), bigcache.Config{
-		Shards:             32,       // must be the power of 2
-		LifeWindow:         3600,     // ttl
-		CleanWindow:        360,      // 1/10 life-window
-		MaxEntriesInWindow: 15000000, // max entries in life-window, 15M
-		MaxEntrySize:       8,        // in bytes, an int64 is 8 bytes
-	})
+const (
+	// entryHeaderSize is size of expire time header prepended to every local cache entry
+	entryHeaderSize = 8
+)
 
+type (
+	// IBigCache is local in-memory cache, every entry expires after the life window and optionally after its own TTL
+	IBigCache interface {
+		Get(key string) ([]byte, error)
+		Set(key string, data []byte) error
+		// SetWithTTL set entry expiring after ttl, ttl longer than life window is capped by the life window
+		SetWithTTL(key string, data []byte, ttl time.Duration) error
+		Delete(key string) error
+		// Len return number of entries, including expired entries not yet cleaned
+		Len() int
+		// Reset removes every entry without calling OnRemove callback
+		Reset() error
+		Stats() BigCacheStats
+		// KeyStats return number of Get of the key, it is only counted when stats-enabled is set
+		KeyStats(key string) EntryStats
+		// Iterate calls fn for every non expired entry until fn return false
+		Iterate(fn func(key string, data []byte) bool) error
+		// OnRemove sets callback called when entry is removed by expiration, lack of space or Delete.
+		// It is called while the shard is locked, so it must not call the cache.
+		OnRemove(fn func(key string, data []byte, reason RemoveReason))
+	}
+
+	// RemoveReason is why local cache entry is removed, see RemoveExpired, RemoveNoSpace and RemoveDeleted
+	RemoveReason = bigcache.RemoveReason
+
+	// BigCacheStats is hit, miss and collision count of local cache
+	BigCacheStats = bigcache.Stats
+
+	// EntryStats is stats of local cache entry
+	EntryStats struct {
+		Requests uint32
+	}
+
+	bigCache struct {
+		bigCache *bigcache.BigCache
+		onRemove atomic.Value
+	}
+)
+
+// NewBigCache return local in-memory cache configured by BigCache config of given name.
+// Default config is used if no name given or the config does not exist.
+func NewBigCache(name ...string) (IBigCache, error) {
+	conf := &config.BigCacheConf{}
+	if len(name) > 0 {
+		if c, ok := config.Get().BigCache[name[0]]; ok && c != nil {
+			conf = c
+		}
+	}
+
+	c := &bigCache{}
+	bcConf := bigCacheConfig(conf)
+	bcConf.OnRemoveWithReason = c.removed
+
+	bc, err := bigcache.New(context.Background(), bcConf)
 	if err != nil {
-		return nil
+		return nil, errors.Wrap(err, "[NewBigCache] fail to init bigcache")
+	}
+
+	c.bigCache = bc
+	return c, nil
+}
+
+// bigCacheConfig convert config into bigcache config and fill its default value.
+// bigcache is already sharded, so no lock is needed on top of it.
+func bigCacheConfig(conf *config.BigCacheConf) bigcache.Config {
+	shards := conf.Shards
+	if shards <= 0 {
+		shards = defaultBigCacheShards
+	}
+	lifeWindow := conf.LifeWindowSec
+	if lifeWindow <= 0 {
+		lifeWindow = defaultBigCacheLifeWindowSec
+	}
+	cleanWindow := conf.CleanWindowSec
+	if cleanWindow <= 0 {
+		// 1/10 life-window
+		cleanWindow = lifeWindow / 10
+		if cleanWindow == 0 {
+			cleanWindow = 1
+		}
+	}
+	maxEntries := conf.MaxEntriesInWindow
+	if maxEntries <= 0 {
+		maxEntries = defaultBigCacheMaxEntriesInWindow
+	}
+	maxEntrySize := conf.MaxEntrySizeByte
+	if maxEntrySize <= 0 {
+		maxEntrySize = defaultBigCacheMaxEntrySizeByte
+	}
+	hardMaxSize := conf.HardMaxCacheSizeMB
+	if hardMaxSize <= 0 {
+		hardMaxSize = defaultBigCacheHardMaxSizeMB
 	}
 
-	return &bigCache{
-		bigCache: bc,
-		mx:       new(sync.Mutex),
+	return bigcache.Config{
+		Shards:             shards, // must be the power of 2
+		LifeWindow:         time.Duration(lifeWindow) * time.Second,
+		CleanWindow:        time.Duration(cleanWindow) * time.Second,
+		MaxEntriesInWindow: maxEntries,   // only used to calculate initial size
+		MaxEntrySize:       maxEntrySize, // in bytes, only used to calculate initial size
+		HardMaxCacheSize:   hardMaxSize,  // in MB
+		StatsEnabled:       conf.StatsEnabled,
 	}
 }
 
+// Get return data of the key, bigcache.ErrEntryNotFound is returned if the key does not exist or expired
 func (bc *bigCache) Get(key string) ([]byte, error) {
 	if bc == nil || bc.bigCache == nil {
 		return nil, errors.New("bigcache is nil")
 	}
-	bc.mx.Lock()
-	defer bc.mx.Unlock()
-	return bc.bigCache.Get(key)
+
+	entry, err := bc.bigCache.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, expired := unwrapEntry(entry, time.Now())
+	if expired {
+		// removal is reported as expired by the callback since the entry is already past its TTL
+		_ = bc.bigCache.Delete(key)
+		return nil, bigcache.ErrEntryNotFound
+	}
+	return data, nil
 }
 
 func (bc *bigCache) Set(key string, data []byte) error {
+	return bc.SetWithTTL(key, data, 0)
+}
+
+func (bc *bigCache) SetWithTTL(key string, data []byte, ttl time.Duration) error {
+	if bc == nil || bc.bigCache == nil {
+		return errors.New("bigcache is nil")
+	}
+
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	return bc.bigCache.Set(key, wrapEntry(data, expireAt))
+}
+
+func (bc *bigCache) Delete(key string) error {
 	if bc == nil || bc.bigCache == nil {
 		return errors.New("bigcache is nil")
 	}
-	bc.mx.Lock()
-	defer bc.mx.Unlock()
-	return bc.bigCache.Set(key, data)
+	err := bc.bigCache.Delete(key)
+	if err == bigcache.ErrEntryNotFound {
+		return nil
+	}
+	return err
+}
+
+func (bc *bigCache) Len() int {
+	if bc == nil || bc.bigCache == nil {
+		return 0
+	}
+	return bc.bigCache.Len()
+}
+
+func (bc *bigCache) Reset() error {
+	if bc == nil || bc.bigCache == nil {
+		return errors.New("bigcache is nil")
+	}
+	return bc.bigCache.Reset()
+}
+
+func (bc *bigCache) Stats() BigCacheStats {
+	if bc == nil || bc.bigCache == nil {
+		return BigCacheStats{}
+	}
+	return bc.bigCache.Stats()
+}
+
+func (bc *bigCache) KeyStats(key string) EntryStats {
+	if bc == nil || bc.bigCache == nil {
+		return EntryStats{}
+	}
+	return EntryStats{
+		Requests: bc.bigCache.KeyMetadata(key).RequestCount,
+	}
+}
+
+func (bc *bigCache) Iterate(fn func(key string, data []byte) bool) error {
+	if bc == nil || bc.bigCache == nil {
+		return errors.New("bigcache is nil")
+	}
+
+	now := time.Now()
+	it := bc.bigCache.Iterator()
+	for it.SetNext() {
+		entry, err := it.Value()
+		if err != nil {
+			// entry is removed while iterating
+			continue
+		}
+
+		data, expired := unwrapEntry(entry.Value(), now)
+		if expired {
+			continue
+		}
+		if !fn(entry.Key(), data) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (bc *bigCache) OnRemove(fn func(key string, data []byte, reason RemoveReason)) {
+	bc.onRemove.Store(fn)
+}
+
+// removed is bigcache OnRemoveWithReason callback, it strips entry header before calling OnRemove callback
+func (bc *bigCache) removed(key string, entry []byte, reason RemoveReason) {
+	fn, ok := bc.onRemove.Load().(func(key string, data []byte, reason RemoveReason))
+	if !ok || fn == nil {
+		return
+	}
+
+	data, expired := unwrapEntry(entry, time.Now())
+	if expired {
+		reason = RemoveExpired
+	}
+	fn(key, data, reason)
+}
+
+// wrapEntry prepends expire time in unix nano to data, zero means the entry only expires by the life window
+func wrapEntry(data []byte, expireAt time.Time) []byte {
+	entry := make([]byte, entryHeaderSize+len(data))
+	if !expireAt.IsZero() {
+		binary.BigEndian.PutUint64(entry, uint64(expireAt.UnixNano()))
+	}
+	copy(entry[entryHeaderSize:], data)
+	return entry
+}
+
+// unwrapEntry return data of the entry and whether it is past its TTL
+func unwrapEntry(entry []byte, now time.Time) (data []byte, expired bool) {
+	if len(entry) < entryHeaderSize {
+		return entry, false
+	}
+
+	expireAt := int64(binary.BigEndian.Uint64(entry))
+	return entry[entryHeaderSize:], expireAt != 0 && now.UnixNano() >= expireAt
 }
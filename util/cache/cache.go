@@ -0,0 +1,679 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	redisclient "github.com/golang-base-template/util/cache/client"
+)
+
+type (
+	// Z is a sorted set member with its score
+	Z struct {
+		Score  float64
+		Member interface{}
+	}
+
+	// ICache is an interface for non pipelined redis command, each command is sent to redis immediately
+	ICache interface {
+		Get(ctx context.Context, key string) (res string, err error)
+		Set(ctx context.Context, key string, value string, expire time.Duration) (err error)
+		SetNX(ctx context.Context, key, value string, expire time.Duration) (res bool, err error)
+		GetSet(ctx context.Context, key, value string) (res string, err error)
+		MGet(ctx context.Context, keys ...string) (res []interface{}, err error)
+		MSet(ctx context.Context, pairs ...interface{}) (err error)
+		Del(ctx context.Context, keys ...string) (res int64, err error)
+		Unlink(ctx context.Context, keys ...string) (res int64, err error)
+		Exists(ctx context.Context, key string) (res bool, err error)
+		Expire(ctx context.Context, key string, expire time.Duration) (res bool, err error)
+		TTL(ctx context.Context, key string) (res time.Duration, err error)
+		Incr(ctx context.Context, key string) (res int64, err error)
+		IncrBy(ctx context.Context, key string, value int64) (res int64, err error)
+		SetValue(ctx context.Context, key string, value interface{}, expire time.Duration) (err error)
+		GetValue(ctx context.Context, key string, dst interface{}) (err error)
+
+		HGet(ctx context.Context, key, field string) (res string, err error)
+		HSet(ctx context.Context, key, field string, value interface{}) (res bool, err error)
+		HSetNX(ctx context.Context, key, field, value string) (res bool, err error)
+		HMGet(ctx context.Context, key string, fields []string) (res []interface{}, err error)
+		HMSet(ctx context.Context, key string, data map[string]string) (err error)
+		HGetAll(ctx context.Context, key string) (res map[string]string, err error)
+		HDel(ctx context.Context, key string, fields ...string) (res int64, err error)
+		HIncrBy(ctx context.Context, key, field string, incr int64) (res int64, err error)
+		HMSetStruct(ctx context.Context, key string, value interface{}) (err error)
+		HGetAllStruct(ctx context.Context, key string, dst interface{}) (err error)
+
+		ZAdd(ctx context.Context, key string, members ...Z) (res int64, err error)
+		ZRangeByScore(ctx context.Context, key, min, max string, offset, count int64) (res []string, err error)
+		ZRem(ctx context.Context, key string, members ...interface{}) (res int64, err error)
+
+		SAdd(ctx context.Context, key string, data ...interface{}) (res int64, err error)
+		SRem(ctx context.Context, key string, data ...interface{}) (res int64, err error)
+		SMembers(ctx context.Context, key string) (res []string, err error)
+		SIsMember(ctx context.Context, key string, member interface{}) (res bool, err error)
+
+		LPush(ctx context.Context, key string, data ...interface{}) (res int64, err error)
+		RPush(ctx context.Context, key string, data ...interface{}) (res int64, err error)
+		LPop(ctx context.Context, key string) (res string, err error)
+		LRange(ctx context.Context, key string, start, stop int64) (res []string, err error)
+		LTrim(ctx context.Context, key string, start, stop int64) (err error)
+
+		EvalScript(ctx context.Context, name string, keys []string, args ...interface{}) (res interface{}, err error)
+		Watch(ctx context.Context, keys []string, fn func(tx ICacheTx) error) (err error)
+	}
+
+	cacheClient struct {
+		conn string
+	}
+
+	// TimeoutError is returned by command and pipeline Exec which is not completed before ctx deadline
+	// or client read/write timeout, use IsTimeout to check it
+	TimeoutError = redisclient.TimeoutError
+)
+
+// IsTimeout reports whether err is caused by redis timeout, err may be wrapped
+func IsTimeout(err error) bool {
+	return redisclient.IsTimeout(err)
+}
+
+// NewCache return non pipelined cache client for given connection, CacheGBT is used if no connection given
+func NewCache(conn ...string) ICache {
+	c := &cacheClient{
+		conn: CacheGBT,
+	}
+
+	if len(conn) > 0 {
+		c.conn = conn[0]
+	}
+
+	return c
+}
+
+// client get redis connection of the cache client
+func (c *cacheClient) client() (redisclient.Redis, error) {
+	rds, err := redisclient.GetConnection(c.conn)
+	if err != nil {
+		return nil, errors.Wrap(ErrConn, err.Error())
+	}
+	return rds, nil
+}
+
+// Get get string value of key, ErrCacheMiss is returned if key does not exist
+func (c *cacheClient) Get(ctx context.Context, key string) (res string, err error) {
+	if key == "" {
+		return "", errors.Wrap(ErrInvalidKeyorField, "[Get]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return "", errors.Wrap(err, "[Get]")
+	}
+
+	return stringReply(rds.Get(ctx, key).Result())
+}
+
+// Set will set key value to redis
+func (c *cacheClient) Set(ctx context.Context, key string, value string, expire time.Duration) (err error) {
+	if key == "" || value == "" {
+		return errors.Wrap(ErrInvalidKeyorField, "[Set]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return errors.Wrap(err, "[Set]")
+	}
+
+	return rds.Set(ctx, key, value, expire).Err()
+}
+
+// SetNX will set key value to redis only if key not exists.
+// Non zero expire is sent as SET key value NX PX expire
+func (c *cacheClient) SetNX(ctx context.Context, key, value string, expire time.Duration) (res bool, err error) {
+	if key == "" || value == "" {
+		return false, errors.Wrap(ErrInvalidKeyorField, "[SetNX]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return false, errors.Wrap(err, "[SetNX]")
+	}
+
+	return rds.SetNX(ctx, key, value, expire).Result()
+}
+
+// GetSet set new value to key and return its old value, ErrCacheMiss is returned if key did not exist
+func (c *cacheClient) GetSet(ctx context.Context, key, value string) (res string, err error) {
+	if key == "" || value == "" {
+		return "", errors.Wrap(ErrInvalidKeyorField, "[GetSet]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return "", errors.Wrap(err, "[GetSet]")
+	}
+
+	return stringReply(rds.GetSet(ctx, key, value).Result())
+}
+
+// MGet get some key with string type redis, missing key is returned as nil
+func (c *cacheClient) MGet(ctx context.Context, keys ...string) (res []interface{}, err error) {
+	if len(keys) == 0 {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[MGet]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return nil, errors.Wrap(err, "[MGet]")
+	}
+
+	return rds.MGet(ctx, keys...).Result()
+}
+
+// MSet set multiple key with string type redis.
+// Data represent pair data (e.g. key value key value)
+func (c *cacheClient) MSet(ctx context.Context, pairs ...interface{}) (err error) {
+	if len(pairs) == 0 || len(pairs)%2 == 1 {
+		return errors.Wrap(ErrInvalidKeyorField, "[MSet]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return errors.Wrap(err, "[MSet]")
+	}
+
+	return rds.MSet(ctx, pairs...).Err()
+}
+
+// Del delete keys from redis
+func (c *cacheClient) Del(ctx context.Context, keys ...string) (res int64, err error) {
+	if len(keys) == 0 {
+		return 0, errors.Wrap(ErrInvalidKeyorField, "[Del]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return 0, errors.Wrap(err, "[Del]")
+	}
+
+	return rds.Del(ctx, keys...).Result()
+}
+
+// Unlink delete keys from redis, the memory is reclaimed asynchronously by redis
+func (c *cacheClient) Unlink(ctx context.Context, keys ...string) (res int64, err error) {
+	if len(keys) == 0 {
+		return 0, errors.Wrap(ErrInvalidKeyorField, "[Unlink]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return 0, errors.Wrap(err, "[Unlink]")
+	}
+
+	return rds.Unlink(ctx, keys...).Result()
+}
+
+// Exists check whether key exists in redis
+func (c *cacheClient) Exists(ctx context.Context, key string) (res bool, err error) {
+	if key == "" {
+		return false, errors.Wrap(ErrInvalidKeyorField, "[Exists]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return false, errors.Wrap(err, "[Exists]")
+	}
+
+	n, err := rds.Exists(ctx, key).Result()
+	return n > 0, err
+}
+
+// Expire set expire time to key redis
+func (c *cacheClient) Expire(ctx context.Context, key string, expire time.Duration) (res bool, err error) {
+	if key == "" {
+		return false, errors.Wrap(ErrInvalidKeyorField, "[Expire]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return false, errors.Wrap(err, "[Expire]")
+	}
+
+	return rds.Expire(ctx, key, expire).Result()
+}
+
+// TTL get remaining time to live of key, negative duration is returned when the key does not exist or has no expire
+func (c *cacheClient) TTL(ctx context.Context, key string) (res time.Duration, err error) {
+	if key == "" {
+		return 0, errors.Wrap(ErrInvalidKeyorField, "[TTL]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return 0, errors.Wrap(err, "[TTL]")
+	}
+
+	return rds.TTL(ctx, key).Result()
+}
+
+// Incr increments integer value of key by one
+func (c *cacheClient) Incr(ctx context.Context, key string) (res int64, err error) {
+	if key == "" {
+		return 0, errors.Wrap(ErrInvalidKeyorField, "[Incr]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return 0, errors.Wrap(err, "[Incr]")
+	}
+
+	return rds.Incr(ctx, key).Result()
+}
+
+// IncrBy increments integer value of key by given value
+func (c *cacheClient) IncrBy(ctx context.Context, key string, value int64) (res int64, err error) {
+	if key == "" {
+		return 0, errors.Wrap(ErrInvalidKeyorField, "[IncrBy]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return 0, errors.Wrap(err, "[IncrBy]")
+	}
+
+	return rds.IncrBy(ctx, key, value).Result()
+}
+
+// SetValue encode value with DefaultSerializer and set it to redis
+func (c *cacheClient) SetValue(ctx context.Context, key string, value interface{}, expire time.Duration) (err error) {
+	if key == "" || value == nil {
+		return errors.Wrap(ErrInvalidKeyorField, "[SetValue]")
+	}
+
+	data, err := DefaultSerializer.Encode(value)
+	if err != nil {
+		return errors.Wrap(err, "[SetValue]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return errors.Wrap(err, "[SetValue]")
+	}
+
+	return rds.Set(ctx, key, data, expire).Err()
+}
+
+// GetValue decode value written by SetValue into dst, ErrCacheMiss is returned if key does not exist
+func (c *cacheClient) GetValue(ctx context.Context, key string, dst interface{}) (err error) {
+	if key == "" {
+		return errors.Wrap(ErrInvalidKeyorField, "[GetValue]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return errors.Wrap(err, "[GetValue]")
+	}
+
+	data, err := rds.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return ErrCacheMiss
+	}
+	if err != nil {
+		return err
+	}
+
+	return Decode(data, dst)
+}
+
+// HGet get a field from redis hash, ErrCacheMiss is returned if key or field does not exist
+func (c *cacheClient) HGet(ctx context.Context, key, field string) (res string, err error) {
+	if key == "" || field == "" {
+		return "", errors.Wrap(ErrInvalidKeyorField, "[HGet]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return "", errors.Wrap(err, "[HGet]")
+	}
+
+	return stringReply(rds.HGet(ctx, key, field).Result())
+}
+
+// HSet set a field of redis hash, the result is true when field is newly created
+func (c *cacheClient) HSet(ctx context.Context, key, field string, value interface{}) (res bool, err error) {
+	if key == "" || field == "" {
+		return false, errors.Wrap(ErrInvalidKeyorField, "[HSet]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return false, errors.Wrap(err, "[HSet]")
+	}
+
+	n, err := rds.HSet(ctx, key, field, value).Result()
+	return n > 0, err
+}
+
+// HSetNX will set hash field to redis if field not exists
+func (c *cacheClient) HSetNX(ctx context.Context, key, field, value string) (res bool, err error) {
+	if key == "" || field == "" {
+		return false, errors.Wrap(ErrInvalidKeyorField, "[HSetNX]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return false, errors.Wrap(err, "[HSetNX]")
+	}
+
+	return rds.HSetNX(ctx, key, field, value).Result()
+}
+
+// HMGet get some fields from key redis, missing field is returned as nil
+func (c *cacheClient) HMGet(ctx context.Context, key string, fields []string) (res []interface{}, err error) {
+	if key == "" || len(fields) == 0 {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[HMGet]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return nil, errors.Wrap(err, "[HMGet]")
+	}
+
+	return rds.HMGet(ctx, key, fields...).Result()
+}
+
+// HMSet set some fields from key redis
+func (c *cacheClient) HMSet(ctx context.Context, key string, data map[string]string) (err error) {
+	if key == "" || len(data) == 0 {
+		return errors.Wrap(ErrInvalidKeyorField, "[HMSet]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return errors.Wrap(err, "[HMSet]")
+	}
+
+	return rds.HSet(ctx, key, data).Err()
+}
+
+// HGetAll get all fields from redis hash
+func (c *cacheClient) HGetAll(ctx context.Context, key string) (res map[string]string, err error) {
+	if key == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[HGetAll]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return nil, errors.Wrap(err, "[HGetAll]")
+	}
+
+	return rds.HGetAll(ctx, key).Result()
+}
+
+// HMSetStruct set struct fields into redis hash using cache tag (see StructToHash)
+func (c *cacheClient) HMSetStruct(ctx context.Context, key string, value interface{}) (err error) {
+	data, err := StructToHash(value)
+	if err != nil {
+		return errors.Wrap(err, "[HMSetStruct]")
+	}
+
+	return c.HMSet(ctx, key, data)
+}
+
+// HGetAllStruct fill struct pointed by dst from redis hash, ErrCacheMiss is returned if key does not exist
+func (c *cacheClient) HGetAllStruct(ctx context.Context, key string, dst interface{}) (err error) {
+	data, err := c.HGetAll(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if len(data) == 0 {
+		return ErrCacheMiss
+	}
+
+	return HashToStruct(data, dst)
+}
+
+// HDel to delete field from key redis
+func (c *cacheClient) HDel(ctx context.Context, key string, fields ...string) (res int64, err error) {
+	if key == "" || len(fields) == 0 {
+		return 0, errors.Wrap(ErrInvalidKeyorField, "[HDel]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return 0, errors.Wrap(err, "[HDel]")
+	}
+
+	return rds.HDel(ctx, key, fields...).Result()
+}
+
+// HIncrBy increments integer value of hash field by given value
+func (c *cacheClient) HIncrBy(ctx context.Context, key, field string, incr int64) (res int64, err error) {
+	if key == "" || field == "" {
+		return 0, errors.Wrap(ErrInvalidKeyorField, "[HIncrBy]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return 0, errors.Wrap(err, "[HIncrBy]")
+	}
+
+	return rds.HIncrBy(ctx, key, field, incr).Result()
+}
+
+// ZAdd add members with score to sorted set
+func (c *cacheClient) ZAdd(ctx context.Context, key string, members ...Z) (res int64, err error) {
+	if key == "" || len(members) == 0 {
+		return 0, errors.Wrap(ErrInvalidKeyorField, "[ZAdd]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return 0, errors.Wrap(err, "[ZAdd]")
+	}
+
+	return rds.ZAdd(ctx, key, toRedisZ(members)...).Result()
+}
+
+// ZRangeByScore get members of sorted set within score min and max (e.g. "-inf", "(10", "+inf").
+// Use count 0 to retrieve all members in range.
+func (c *cacheClient) ZRangeByScore(ctx context.Context, key, min, max string, offset, count int64) (res []string, err error) {
+	if key == "" || min == "" || max == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[ZRangeByScore]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return nil, errors.Wrap(err, "[ZRangeByScore]")
+	}
+
+	return rds.ZRangeByScore(ctx, key, zRangeBy(min, max, offset, count)).Result()
+}
+
+// ZRem delete members from sorted set
+func (c *cacheClient) ZRem(ctx context.Context, key string, members ...interface{}) (res int64, err error) {
+	if key == "" || len(members) == 0 {
+		return 0, errors.Wrap(ErrInvalidKeyorField, "[ZRem]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return 0, errors.Wrap(err, "[ZRem]")
+	}
+
+	return rds.ZRem(ctx, key, members...).Result()
+}
+
+// SAdd add to set to redis
+func (c *cacheClient) SAdd(ctx context.Context, key string, data ...interface{}) (res int64, err error) {
+	if key == "" || len(data) == 0 {
+		return 0, errors.Wrap(ErrInvalidKeyorField, "[SAdd]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return 0, errors.Wrap(err, "[SAdd]")
+	}
+
+	return rds.SAdd(ctx, key, data...).Result()
+}
+
+// SRem delete member data in specific key
+func (c *cacheClient) SRem(ctx context.Context, key string, data ...interface{}) (res int64, err error) {
+	if key == "" || len(data) == 0 {
+		return 0, errors.Wrap(ErrInvalidKeyorField, "[SRem]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return 0, errors.Wrap(err, "[SRem]")
+	}
+
+	return rds.SRem(ctx, key, data...).Result()
+}
+
+// SMembers get all members of set
+func (c *cacheClient) SMembers(ctx context.Context, key string) (res []string, err error) {
+	if key == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[SMembers]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return nil, errors.Wrap(err, "[SMembers]")
+	}
+
+	return rds.SMembers(ctx, key).Result()
+}
+
+// SIsMember check whether member is part of set
+func (c *cacheClient) SIsMember(ctx context.Context, key string, member interface{}) (res bool, err error) {
+	if key == "" || member == nil {
+		return false, errors.Wrap(ErrInvalidKeyorField, "[SIsMember]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return false, errors.Wrap(err, "[SIsMember]")
+	}
+
+	return rds.SIsMember(ctx, key, member).Result()
+}
+
+// LPush prepend data to list
+func (c *cacheClient) LPush(ctx context.Context, key string, data ...interface{}) (res int64, err error) {
+	if key == "" || len(data) == 0 {
+		return 0, errors.Wrap(ErrInvalidKeyorField, "[LPush]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return 0, errors.Wrap(err, "[LPush]")
+	}
+
+	return rds.LPush(ctx, key, data...).Result()
+}
+
+// RPush append data to list
+func (c *cacheClient) RPush(ctx context.Context, key string, data ...interface{}) (res int64, err error) {
+	if key == "" || len(data) == 0 {
+		return 0, errors.Wrap(ErrInvalidKeyorField, "[RPush]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return 0, errors.Wrap(err, "[RPush]")
+	}
+
+	return rds.RPush(ctx, key, data...).Result()
+}
+
+// LPop remove and get the first element of list, ErrCacheMiss is returned if list is empty
+func (c *cacheClient) LPop(ctx context.Context, key string) (res string, err error) {
+	if key == "" {
+		return "", errors.Wrap(ErrInvalidKeyorField, "[LPop]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return "", errors.Wrap(err, "[LPop]")
+	}
+
+	return stringReply(rds.LPop(ctx, key).Result())
+}
+
+// LRange get list from key redis.
+// To retrieve all data in list, use start 0 and stop -1.
+func (c *cacheClient) LRange(ctx context.Context, key string, start, stop int64) (res []string, err error) {
+	if key == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[LRange]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return nil, errors.Wrap(err, "[LRange]")
+	}
+
+	return rds.LRange(ctx, key, start, stop).Result()
+}
+
+// LTrim trim list to the specified range
+func (c *cacheClient) LTrim(ctx context.Context, key string, start, stop int64) (err error) {
+	if key == "" {
+		return errors.Wrap(ErrInvalidKeyorField, "[LTrim]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return errors.Wrap(err, "[LTrim]")
+	}
+
+	return rds.LTrim(ctx, key, start, stop).Err()
+}
+
+// stringReply converts redis nil reply into ErrCacheMiss
+func stringReply(val string, err error) (string, error) {
+	if err == redis.Nil {
+		return "", ErrCacheMiss
+	}
+	return val, err
+}
+
+// toRedisZ converts sorted set members into redis client type
+func toRedisZ(members []Z) []redis.Z {
+	zs := make([]redis.Z, 0, len(members))
+	for _, m := range members {
+		zs = append(zs, redis.Z{
+			Score:  m.Score,
+			Member: m.Member,
+		})
+	}
+	return zs
+}
+
+// zRangeBy build score range option of ZRANGEBYSCORE. LIMIT is sent when offset is not 0 and
+// LIMIT offset 0 replies nothing, so count 0 is sent as -1 to retrieve every member after offset
+func zRangeBy(min, max string, offset, count int64) *redis.ZRangeBy {
+	if count == 0 && offset != 0 {
+		count = -1
+	}
+	return &redis.ZRangeBy{
+		Min:    min,
+		Max:    max,
+		Offset: offset,
+		Count:  count,
+	}
+}
+
+// hashArgs build args of hash command from field value map
+func hashArgs(cmd, key string, data map[string]string) []interface{} {
+	args := make([]interface{}, 0, len(data)*2+2)
+	args = append(args, cmd, key)
+	for field, value := range data {
+		args = append(args, field, value)
+	}
+	return args
+}
@@ -9,9 +9,10 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
-	"gopkg.in/redis.v5"
+	"github.com/redis/go-redis/v9"
 
 	redisclient "github.com/golang-base-template/util/cache/client"
+	"github.com/golang-base-template/util/metrics"
 )
 
 const (
@@ -20,48 +21,66 @@ const (
 
 type (
 	// ResultType is a type for redis pipeline result type
+	//
+	// Deprecated: every pipeline command returns its own typed result, use it instead of Get.
 	ResultType int
 
 	//ICachePipeline is an interface for redis pipeline wrapper used by IMS repository
 	ICachePipeline interface {
 		NewPipeline(ctx context.Context, conn ...string) (txObj ICachePipeline, err error)
-		MGet(ctx context.Context, keys ...string) (err error)
-		MSet(ctx context.Context, pairs ...interface{}) (err error)
-		HMGet(ctx context.Context, key string, field []string) (err error)
-		HMSet(ctx context.Context, key string, data map[string]string) (err error)
-		HDel(ctx context.Context, key string, field ...string) (err error)
-		LRange(ctx context.Context, key string, start, stop int64) (err error)
-		RPush(ctx context.Context, key string, data ...interface{}) (err error)
-		SAdd(ctx context.Context, key string, data ...interface{}) (err error)
-		SRem(ctx context.Context, key string, data ...interface{}) (err error)
-		Del(ctx context.Context, key string) (err error)
-		Expire(ctx context.Context, key string, expire time.Duration) (err error)
+		NewTxPipeline(ctx context.Context, conn ...string) (txObj ICachePipeline, err error)
+		MGet(ctx context.Context, keys ...string) (res *SliceResult, err error)
+		MSet(ctx context.Context, pairs ...interface{}) (res *StatusResult, err error)
+		HMGet(ctx context.Context, key string, field []string) (res *SliceResult, err error)
+		HMSet(ctx context.Context, key string, data map[string]string) (res *StatusResult, err error)
+		HDel(ctx context.Context, key string, field ...string) (res *IntResult, err error)
+		LRange(ctx context.Context, key string, start, stop int64) (res *StringSliceResult, err error)
+		RPush(ctx context.Context, key string, data ...interface{}) (res *IntResult, err error)
+		SAdd(ctx context.Context, key string, data ...interface{}) (res *IntResult, err error)
+		SRem(ctx context.Context, key string, data ...interface{}) (res *IntResult, err error)
+		Del(ctx context.Context, key string) (res *IntResult, err error)
+		Expire(ctx context.Context, key string, expire time.Duration) (res *BoolResult, err error)
+		// Deprecated: use Result() of the value returned by each command instead.
 		Get(ctx context.Context, resType ResultType) (res interface{})
 		Exec(ctx context.Context) (err error)
-		HGetAll(ctx context.Context, keys string) (err error)
-		Set(ctx context.Context, key string, value string, expire time.Duration) (err error)
-		HSetNX(ctx context.Context, key, field, value string) (err error)
+		HGetAll(ctx context.Context, keys string) (res *StringMapResult, err error)
+		Set(ctx context.Context, key string, value string, expire time.Duration) (res *StatusResult, err error)
+		HSetNX(ctx context.Context, key, field, value string) (res *BoolResult, err error)
+		Incr(ctx context.Context, key string) (res *IntResult, err error)
+		IncrBy(ctx context.Context, key string, value int64) (res *IntResult, err error)
+		HIncrBy(ctx context.Context, key, field string, incr int64) (res *IntResult, err error)
+		SetNX(ctx context.Context, key, value string, expire time.Duration) (res *BoolResult, err error)
+		GetSet(ctx context.Context, key, value string) (res *StringResult, err error)
+		TTL(ctx context.Context, key string) (res *DurationResult, err error)
+		Exists(ctx context.Context, key string) (res *BoolResult, err error)
+		ZAdd(ctx context.Context, key string, members ...Z) (res *IntResult, err error)
+		ZRangeByScore(ctx context.Context, key, min, max string, offset, count int64) (res *StringSliceResult, err error)
+		ZRem(ctx context.Context, key string, members ...interface{}) (res *IntResult, err error)
+		SMembers(ctx context.Context, key string) (res *StringSliceResult, err error)
+		SIsMember(ctx context.Context, key string, member interface{}) (res *BoolResult, err error)
+		LPush(ctx context.Context, key string, data ...interface{}) (res *IntResult, err error)
+		LPop(ctx context.Context, key string) (res *StringResult, err error)
+		LTrim(ctx context.Context, key string, start, stop int64) (res *StatusResult, err error)
+		HGet(ctx context.Context, key, field string) (res *StringResult, err error)
+		HSet(ctx context.Context, key, field string, value interface{}) (res *BoolResult, err error)
+		Unlink(ctx context.Context, keys ...string) (res *IntResult, err error)
+		SetValue(ctx context.Context, key string, value interface{}, expire time.Duration) (res *StatusResult, err error)
+		HMSetStruct(ctx context.Context, key string, value interface{}) (res *StatusResult, err error)
+		EvalScript(ctx context.Context, name string, keys []string, args ...interface{}) (res *ScriptResult, err error)
 	}
 
 	cachePipeline struct {
 		conn     string
-		rdsConn  *redis.Client
-		pipe     *redis.Pipeline
+		rdsConn  redisclient.Redis
+		pipe     redis.Pipeliner
+		tx       bool
 		didExec  bool
 		cmdCount int64
 
-		// sliceCmds point to redis hash result
-		sliceCmds []*redis.SliceCmd
-		// stringSliceCmds point to redis list result
-		stringSliceCmds []*redis.StringSliceCmd
-		// statusCmds point to redis set command result
-		statusCmds []*redis.StatusCmd
-		// intCmds point to redis int result
-		intCmds []*redis.IntCmd
-		// boolCmds point to redis bool result
-		boolCmds []*redis.BoolCmd
-		// stringStringMapCmd point to get all result
-		stringStringMapCmd []*redis.StringStringMapCmd
+		// results holds command results per result type, only used by deprecated Get
+		results map[ResultType][]resulter
+		// scripts holds queued scripts so they can be retried with EVAL on NOSCRIPT
+		scripts []scriptCall
 	}
 )
 
@@ -91,6 +110,12 @@ var (
 	ResultHGETALL ResultType = 10
 	// ResultHSetNX is result type for HSetNX
 	ResultHSetNX ResultType = 11
+	// ResultSet is result type for Set
+	ResultSet ResultType = 12
+	// ResultHDel is result type for HDel
+	ResultHDel ResultType = 13
+	// ResultSRem is result type for SRem
+	ResultSRem ResultType = 14
 
 	//ERROR VARIABLE
 
@@ -104,6 +129,14 @@ var (
 	ErrConn = errors.New("redis: cannot get connection to redis")
 	// ErrUninitialized is not initialized. Please call NewPipeline() first before other command
 	ErrUninitialized = errors.New("redis: pipeline is uninitialized")
+	// ErrNotExecuted is returned by command result when it is read before Exec is issued
+	ErrNotExecuted = errors.New("redis: pipeline is not executed yet")
+	// ErrCacheMiss is returned when requested key or field does not exist in redis
+	ErrCacheMiss = errors.New("redis: key does not exist")
+	// ErrCircuitOpen is returned while redis of the connection is unavailable, see RedisConf Degrade
+	ErrCircuitOpen = redisclient.ErrCircuitOpen
+	// ErrTxFailed is returned by transaction Exec when a watched key is changed, Watch retries on it
+	ErrTxFailed = redis.TxFailedErr
 )
 
 // NewPkgPipeline return pipeline Obj interface
@@ -122,10 +155,20 @@ func NewPkgPipeline(conn ...string) (cp ICachePipeline) {
 	return cpObj
 }
 
-// NewPipeline starts a redis transaction.
+// NewPipeline starts a redis pipeline, queued commands are sent at once but they are not atomic.
 // The function will check the connection to redis and it will return if error occured.
-// To finish transaction, issue commit.
+// To send the queued commands, issue Exec.
 func (cp *cachePipeline) NewPipeline(ctx context.Context, conn ...string) (txObj ICachePipeline, err error) {
+	return cp.newPipeline(false, conn...)
+}
+
+// NewTxPipeline starts a redis transaction, queued commands are wrapped in MULTI/EXEC on Exec
+// so they are executed atomically. Use ICache Watch for check-and-set.
+func (cp *cachePipeline) NewTxPipeline(ctx context.Context, conn ...string) (txObj ICachePipeline, err error) {
+	return cp.newPipeline(true, conn...)
+}
+
+func (cp *cachePipeline) newPipeline(tx bool, conn ...string) (txObj ICachePipeline, err error) {
 
 	connName := cp.conn
 	if len(conn) > 0 {
@@ -138,287 +181,226 @@ func (cp *cachePipeline) NewPipeline(ctx context.Context, conn ...string) (txObj
 		return nil, errors.Wrap(ErrConn, "[NewPipeline]")
 	}
 
+	// fail fast while redis is unavailable unless the connection degrades to no-op
+	if !redisclient.IsAvailable(connName) && redisclient.DegradeMode(connName) != redisclient.DegradeNoop {
+		return nil, errors.Wrap(ErrCircuitOpen, "[NewPipeline]")
+	}
+
+	pipe := rds.Pipeline()
+	if tx {
+		pipe = rds.TxPipeline()
+	}
+
 	return &cachePipeline{
-		conn:            connName,
-		rdsConn:         rds,
-		pipe:            rds.Pipeline(),
-		sliceCmds:       []*redis.SliceCmd{},
-		stringSliceCmds: []*redis.StringSliceCmd{},
-		statusCmds:      []*redis.StatusCmd{},
-		intCmds:         []*redis.IntCmd{},
+		conn:    connName,
+		rdsConn: rds,
+		pipe:    pipe,
+		tx:      tx,
+		results: make(map[ResultType][]resulter),
 	}, nil
 }
 
+// add registers queued command result so it can be retrieved by deprecated Get
+func (cp *cachePipeline) add(resType ResultType, res resulter) {
+	cp.results[resType] = append(cp.results[resType], res)
+	cp.cmdCount++
+}
+
 // MGet get some key with string type redis.
 // Data will not be removed on redis, until commit is being issued.
-func (cp *cachePipeline) MGet(ctx context.Context, keys ...string) (err error) {
+func (cp *cachePipeline) MGet(ctx context.Context, keys ...string) (res *SliceResult, err error) {
 
 	if cp.conn == "" {
-		return errors.Wrap(ErrUninitialized, "[MGet]")
+		return nil, errors.Wrap(ErrUninitialized, "[MGet]")
 	}
 
 	//skip wrong set
 	if len(keys) == 0 {
-		return errors.Wrap(ErrInvalidKeyorField, "[MGet]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[MGet]")
 	}
 
-	mget := cp.pipe.MGet(keys...)
-
-	//set data
-	cp.sliceCmds = append(cp.sliceCmds, mget)
-
-	cp.cmdCount++
+	res = &SliceResult{pipelineResult{cp}, cp.pipe.MGet(ctx, keys...)}
+	cp.add(ResultMGet, res)
 	return
 }
 
 // MSet set multiple key with string type redis.
 // Data represent pair data (e.g. key value key value)
-func (cp *cachePipeline) MSet(ctx context.Context, pairs ...interface{}) (err error) {
+func (cp *cachePipeline) MSet(ctx context.Context, pairs ...interface{}) (res *StatusResult, err error) {
 
 	if cp.conn == "" {
-		return errors.Wrap(ErrUninitialized, "[MSet]")
+		return nil, errors.Wrap(ErrUninitialized, "[MSet]")
 	}
 
 	//skip wrong set
 	if len(pairs) == 0 {
-		return errors.Wrap(ErrInvalidKeyorField, "[MSet]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[MSet]")
 	}
 
 	// unbalanced pair
 	if len(pairs)%2 == 1 {
-		return errors.Wrap(ErrInvalidKeyorField, "[MSet]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[MSet]")
 	}
 
-	mset := cp.pipe.MSet(pairs...)
-
-	//set data
-	cp.statusCmds = append(cp.statusCmds, mset)
-
-	cp.cmdCount++
+	res = &StatusResult{pipelineResult{cp}, cp.pipe.MSet(ctx, pairs...)}
+	cp.add(ResultMSet, res)
 	return
 }
 
 // HMGet get some fields from key redis.
 // Data will not be removed on redis, until commit is being issued.
-func (cp *cachePipeline) HMGet(ctx context.Context, key string, fields []string) (err error) {
+func (cp *cachePipeline) HMGet(ctx context.Context, key string, fields []string) (res *SliceResult, err error) {
 
 	if cp.conn == "" {
-		return errors.Wrap(ErrUninitialized, "[HMGet]")
+		return nil, errors.Wrap(ErrUninitialized, "[HMGet]")
 	}
 
 	//skip wrong set
 	if key == "" || len(fields) == 0 {
-		return errors.Wrap(ErrInvalidKeyorField, "[HMGet]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[HMGet]")
 	}
 
-	hmget := cp.pipe.HMGet(key, fields...)
-
-	//set data
-	cp.sliceCmds = append(cp.sliceCmds, hmget)
-
-	cp.cmdCount++
+	res = &SliceResult{pipelineResult{cp}, cp.pipe.HMGet(ctx, key, fields...)}
+	cp.add(ResultHMGet, res)
 	return
 }
 
 // HMSet set some fields from key redis.
-func (cp *cachePipeline) HMSet(ctx context.Context, key string, data map[string]string) (err error) {
+func (cp *cachePipeline) HMSet(ctx context.Context, key string, data map[string]string) (res *StatusResult, err error) {
 
 	//skip wrong set
 	if key == "" || len(data) == 0 {
-		return errors.Wrap(ErrInvalidKeyorField, "[HMSet]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[HMSet]")
 	}
 
-	hmset := cp.pipe.HMSet(key, data)
-
-	//set data
+	// HMSET replies with status, while the client types it as bool
+	cmd := redis.NewStatusCmd(ctx, hashArgs("hmset", key, data)...)
+	cp.pipe.Process(ctx, cmd)
 
-	cp.statusCmds = append(cp.statusCmds, hmset)
-	cp.cmdCount++
+	res = &StatusResult{pipelineResult{cp}, cmd}
+	cp.add(ResultHMSet, res)
 	return
 }
 
 // HDel to delete field from key redis.
-func (cp *cachePipeline) HDel(ctx context.Context, key string, fields ...string) (err error) {
+func (cp *cachePipeline) HDel(ctx context.Context, key string, fields ...string) (res *IntResult, err error) {
 
 	//skip wrong set
 	if key == "" || len(fields) == 0 {
-		return errors.Wrap(ErrInvalidKeyorField, "[HDel]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[HDel]")
 	}
 
-	hdel := cp.pipe.HDel(key, fields...)
-
-	//set data
-	cp.intCmds = append(cp.intCmds, hdel)
-	cp.cmdCount++
+	res = &IntResult{pipelineResult{cp}, cp.pipe.HDel(ctx, key, fields...)}
+	cp.add(ResultHDel, res)
 	return
 }
 
 // LRange get list from key redis.
 // To retrieve all data in list, use start 0 and stop -1.
-func (cp *cachePipeline) LRange(ctx context.Context, key string, start, stop int64) (err error) {
+func (cp *cachePipeline) LRange(ctx context.Context, key string, start, stop int64) (res *StringSliceResult, err error) {
 
 	//skip wrong set
 	if key == "" {
-		return errors.Wrap(ErrInvalidKeyorField, "[LRange]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[LRange]")
 	}
 
-	lrange := cp.pipe.LRange(key, start, stop)
-
-	//set data
-	cp.stringSliceCmds = append(cp.stringSliceCmds, lrange)
-	cp.cmdCount++
+	res = &StringSliceResult{pipelineResult{cp}, cp.pipe.LRange(ctx, key, start, stop)}
+	cp.add(ResultLRANGE, res)
 	return
 }
 
 // RPush set list to redis.
-func (cp *cachePipeline) RPush(ctx context.Context, key string, data ...interface{}) (err error) {
+func (cp *cachePipeline) RPush(ctx context.Context, key string, data ...interface{}) (res *IntResult, err error) {
 
 	//skip wrong set
 	if key == "" || len(data) == 0 {
-		return errors.Wrap(ErrInvalidKeyorField, "[RPush]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[RPush]")
 	}
 
-	cpush := cp.pipe.RPush(key, data...)
-
-	//set data
-	cp.intCmds = append(cp.intCmds, cpush)
-	cp.cmdCount++
+	res = &IntResult{pipelineResult{cp}, cp.pipe.RPush(ctx, key, data...)}
+	cp.add(ResultRPUSH, res)
 	return
 }
 
 // SAdd add to set to redis.
-func (cp *cachePipeline) SAdd(ctx context.Context, key string, data ...interface{}) (err error) {
+func (cp *cachePipeline) SAdd(ctx context.Context, key string, data ...interface{}) (res *IntResult, err error) {
 
 	//skip wrong set
 	if key == "" || len(data) == 0 {
-		return errors.Wrap(ErrInvalidKeyorField, "[SAdd]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[SAdd]")
 	}
 
-	sadd := cp.pipe.SAdd(key, data...)
-
-	//set data
-	cp.intCmds = append(cp.intCmds, sadd)
-	cp.cmdCount++
+	res = &IntResult{pipelineResult{cp}, cp.pipe.SAdd(ctx, key, data...)}
+	cp.add(ResultSADD, res)
 	return
 }
 
 // SRem delete member data in specific key
-func (cp *cachePipeline) SRem(ctx context.Context, key string, data ...interface{}) (err error) {
+func (cp *cachePipeline) SRem(ctx context.Context, key string, data ...interface{}) (res *IntResult, err error) {
 
 	//skip wrong set
 	if key == "" || len(data) == 0 {
-		return errors.Wrap(ErrInvalidKeyorField, "[SRem]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[SRem]")
 	}
 
-	srem := cp.pipe.SRem(key, data...)
-
-	//set data
-	cp.intCmds = append(cp.intCmds, srem)
-	cp.cmdCount++
+	res = &IntResult{pipelineResult{cp}, cp.pipe.SRem(ctx, key, data...)}
+	cp.add(ResultSRem, res)
 	return
 }
 
 // Del delete key from redis.
-func (cp *cachePipeline) Del(ctx context.Context, key string) (err error) {
+func (cp *cachePipeline) Del(ctx context.Context, key string) (res *IntResult, err error) {
 
 	//skip wrong set
 	if key == "" {
-		return errors.Wrap(ErrInvalidKeyorField, "[Del]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[Del]")
 	}
 
-	del := cp.pipe.Del(key)
-
-	//set data
-	cp.intCmds = append(cp.intCmds, del)
-	cp.cmdCount++
+	res = &IntResult{pipelineResult{cp}, cp.pipe.Del(ctx, key)}
+	cp.add(ResultDEL, res)
 	return
 }
 
 // Expire set expire time to key redis.
-func (cp *cachePipeline) Expire(ctx context.Context, key string, expire time.Duration) (err error) {
+func (cp *cachePipeline) Expire(ctx context.Context, key string, expire time.Duration) (res *BoolResult, err error) {
 
 	//skip wrong set
 	if key == "" {
-		return errors.Wrap(ErrInvalidKeyorField, "[Expire]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[Expire]")
 	}
 
-	expRes := cp.pipe.Expire(key, expire)
-
-	//set data
-	cp.boolCmds = append(cp.boolCmds, expRes)
-	cp.cmdCount++
+	res = &BoolResult{pipelineResult{cp}, cp.pipe.Expire(ctx, key, expire)}
+	cp.add(ResultEXPIRE, res)
 	return
 }
 
-// Get finalize command that being pipelined
-// If the exec has been running before, then it cannot be run anymore, please create a new pipeline instead
+// Get returns the oldest unread result of given type, nil is returned when there is no result left or the command failed.
+//
+// Deprecated: errors are discarded by this function, use Result() of the value returned by each command instead.
 func (cp *cachePipeline) Get(ctx context.Context, resType ResultType) (res interface{}) {
 
-	switch resType {
-	case ResultHMGet:
-		if len(cp.sliceCmds) > 0 {
-			res, _ = cp.sliceCmds[0].Result()
-			cp.sliceCmds = cp.sliceCmds[1:]
-		}
-	case ResultHMSet:
-		if len(cp.statusCmds) > 0 {
-			res, _ = cp.statusCmds[0].Result()
-			cp.statusCmds = cp.statusCmds[1:]
-		}
-	case ResultLRANGE:
-		if len(cp.stringSliceCmds) > 0 {
-			res, _ = cp.stringSliceCmds[0].Result()
-			cp.stringSliceCmds = cp.stringSliceCmds[1:]
-		}
-	case ResultRPUSH:
-		if len(cp.intCmds) > 0 {
-			res, _ = cp.intCmds[0].Result()
-			cp.intCmds = cp.intCmds[1:]
-		}
-	case ResultDEL:
-		if len(cp.intCmds) > 0 {
-			res, _ = cp.intCmds[0].Result()
-			cp.intCmds = cp.intCmds[1:]
-		}
-	case ResultSADD:
-		if len(cp.intCmds) > 0 {
-			res, _ = cp.intCmds[0].Result()
-			cp.intCmds = cp.intCmds[1:]
-		}
-	case ResultMGet:
-		if len(cp.sliceCmds) > 0 {
-			res, _ = cp.sliceCmds[0].Result()
-			cp.sliceCmds = cp.sliceCmds[1:]
-		}
-	case ResultMSet:
-		if len(cp.statusCmds) > 0 {
-			res, _ = cp.statusCmds[0].Result()
-			cp.statusCmds = cp.statusCmds[1:]
-		}
-	case ResultEXPIRE:
-		if len(cp.boolCmds) > 0 {
-			res, _ = cp.boolCmds[0].Result()
-			cp.boolCmds = cp.boolCmds[1:]
-		}
-	case ResultHGETALL:
-		if len(cp.stringStringMapCmd) > 0 {
-			res, _ = cp.stringStringMapCmd[0].Result()
-			cp.stringStringMapCmd = cp.stringStringMapCmd[1:]
-		}
-	case ResultHSetNX:
-		if len(cp.boolCmds) > 0 {
-			res, _ = cp.boolCmds[0].Result()
-			cp.boolCmds = cp.boolCmds[1:]
-		}
-	default:
+	if resType < ResultHMGet || resType > ResultSRem {
 		log.Println("[RedisPipeline][Get]result type is not defined", resType)
+		return
+	}
+
+	queue := cp.results[resType]
+	if len(queue) == 0 {
+		return
+	}
+
+	res, err := queue[0].value()
+	if err != nil {
+		res = nil
 	}
+	cp.results[resType] = queue[1:]
 
 	return
 }
 
 // Exec finalize command that being pipelined
-// If the exec has been running before, then it cannot be run anymore, please create a new pipeline instead
+// If the exec has been running before, then it cannot be run anymore, please create a new pipeline instead.
+// Error of each command is kept in its own result, redis nil reply is not considered as an error.
 func (cp *cachePipeline) Exec(ctx context.Context) (err error) {
 	if cp.didExec == true {
 		return nil
@@ -427,67 +409,354 @@ func (cp *cachePipeline) Exec(ctx context.Context) (err error) {
 		return nil
 	}
 
-	_, err = cp.pipe.Exec()
-	if err != nil {
+	metrics.ObserveSize("cache.pipeline.size", cp.cmdCount, cp.conn)
+
+	cmds, err := cp.pipe.Exec(ctx)
+	cp.didExec = true
+	if err != nil && len(cp.scripts) > 0 {
+		err = cp.evalNoScript(ctx, cmds)
+	}
+	if errors.Is(err, ErrCircuitOpen) && redisclient.DegradeMode(cp.conn) == redisclient.DegradeNoop {
+		// redis is unavailable, read is treated as cache miss and fire-and-forget write is dropped,
+		// other command (e.g. INCR, SETNX, script) keeps ErrCircuitOpen since its reply cannot be faked
+		failed := false
+		for _, cmd := range cmds {
+			switch name := cmd.Name(); {
+			case redisclient.IsReadCommand(name):
+				cmd.SetErr(ErrCacheMiss)
+			case redisclient.IsDropCommand(name, cmd.Args()):
+				cmd.SetErr(nil)
+			default:
+				failed = true
+			}
+		}
+		if !failed {
+			return nil
+		}
+	}
+	if err != nil && err != redis.Nil {
 		return errors.Wrapf(err, "[RedisPipeline][Exec] error when execute redis command in pipeline")
 	}
-	cp.didExec = true
 
-	return
+	return nil
 }
 
 // HGetAll get all fields from redis hash
-func (cp *cachePipeline) HGetAll(ctx context.Context, key string) (err error) {
+func (cp *cachePipeline) HGetAll(ctx context.Context, key string) (res *StringMapResult, err error) {
 
 	if cp.conn == "" {
-		return errors.Wrap(ErrUninitialized, "[HGetAll]")
+		return nil, errors.Wrap(ErrUninitialized, "[HGetAll]")
 	}
 
 	//skip wrong set
 	if key == "" {
-		return errors.Wrap(ErrInvalidKeyorField, "[HGetAll]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[HGetAll]")
 	}
 
-	hgetall := cp.pipe.HGetAll(key)
+	res = &StringMapResult{pipelineResult{cp}, cp.pipe.HGetAll(ctx, key)}
+	cp.add(ResultHGETALL, res)
+	return
+}
+
+// Set will set key value to redis
+func (cp *cachePipeline) Set(ctx context.Context, key string, value string, expire time.Duration) (res *StatusResult, err error) {
 
-	//set data
-	cp.stringStringMapCmd = append(cp.stringStringMapCmd, hgetall)
+	//skip wrong set
+	if key == "" || value == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[Set]")
+	}
 
+	res = &StatusResult{pipelineResult{cp}, cp.pipe.Set(ctx, key, value, expire)}
+	cp.add(ResultSet, res)
+	return
+}
+
+// HSetNX will set key value to redis if key not exists
+func (cp *cachePipeline) HSetNX(ctx context.Context, key, field, value string) (res *BoolResult, err error) {
+
+	//skip wrong set
+	if key == "" || field == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[HSetNX]")
+	}
+
+	res = &BoolResult{pipelineResult{cp}, cp.pipe.HSetNX(ctx, key, field, value)}
+	cp.add(ResultHSetNX, res)
+	return
+}
+
+// Incr increments integer value of key by one
+func (cp *cachePipeline) Incr(ctx context.Context, key string) (res *IntResult, err error) {
+
+	//skip wrong set
+	if key == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[Incr]")
+	}
+
+	res = &IntResult{pipelineResult{cp}, cp.pipe.Incr(ctx, key)}
 	cp.cmdCount++
 	return
 }
 
-// Set will set key value to redis
-func (cp *cachePipeline) Set(ctx context.Context, key string, value string, expire time.Duration) (err error) {
+// IncrBy increments integer value of key by given value
+func (cp *cachePipeline) IncrBy(ctx context.Context, key string, value int64) (res *IntResult, err error) {
+
+	//skip wrong set
+	if key == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[IncrBy]")
+	}
+
+	res = &IntResult{pipelineResult{cp}, cp.pipe.IncrBy(ctx, key, value)}
+	cp.cmdCount++
+	return
+}
+
+// HIncrBy increments integer value of hash field by given value
+func (cp *cachePipeline) HIncrBy(ctx context.Context, key, field string, incr int64) (res *IntResult, err error) {
+
+	//skip wrong set
+	if key == "" || field == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[HIncrBy]")
+	}
+
+	res = &IntResult{pipelineResult{cp}, cp.pipe.HIncrBy(ctx, key, field, incr)}
+	cp.cmdCount++
+	return
+}
+
+// SetNX will set key value to redis only if key not exists.
+// Non zero expire is sent as SET key value NX PX expire
+func (cp *cachePipeline) SetNX(ctx context.Context, key, value string, expire time.Duration) (res *BoolResult, err error) {
 
 	//skip wrong set
 	if key == "" || value == "" {
-		return errors.Wrap(ErrInvalidKeyorField, "[Set]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[SetNX]")
 	}
 
-	sadd := cp.pipe.Set(key, value, expire)
+	res = &BoolResult{pipelineResult{cp}, cp.pipe.SetNX(ctx, key, value, expire)}
+	cp.cmdCount++
+	return
+}
+
+// GetSet set new value to key and return its old value
+func (cp *cachePipeline) GetSet(ctx context.Context, key, value string) (res *StringResult, err error) {
+
+	//skip wrong set
+	if key == "" || value == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[GetSet]")
+	}
 
-	//set data
-	cp.statusCmds = append(cp.statusCmds, sadd)
+	res = &StringResult{pipelineResult{cp}, cp.pipe.GetSet(ctx, key, value)}
 	cp.cmdCount++
 	return
 }
 
-// HSetNX will set key value to redis if key not exists
-func (cp *cachePipeline) HSetNX(ctx context.Context, key, field, value string) (err error) {
+// TTL get remaining time to live of key
+func (cp *cachePipeline) TTL(ctx context.Context, key string) (res *DurationResult, err error) {
+
+	//skip wrong set
+	if key == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[TTL]")
+	}
+
+	res = &DurationResult{pipelineResult{cp}, cp.pipe.TTL(ctx, key)}
+	cp.cmdCount++
+	return
+}
+
+// Exists check whether key exists in redis
+func (cp *cachePipeline) Exists(ctx context.Context, key string) (res *BoolResult, err error) {
+
+	//skip wrong set
+	if key == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[Exists]")
+	}
+
+	// EXISTS of single key replies 0 or 1, read it as bool
+	cmd := redis.NewBoolCmd(ctx, "exists", key)
+	cp.pipe.Process(ctx, cmd)
+
+	res = &BoolResult{pipelineResult{cp}, cmd}
+	cp.cmdCount++
+	return
+}
+
+// ZAdd add members with score to sorted set
+func (cp *cachePipeline) ZAdd(ctx context.Context, key string, members ...Z) (res *IntResult, err error) {
+
+	//skip wrong set
+	if key == "" || len(members) == 0 {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[ZAdd]")
+	}
+
+	res = &IntResult{pipelineResult{cp}, cp.pipe.ZAdd(ctx, key, toRedisZ(members)...)}
+	cp.cmdCount++
+	return
+}
+
+// ZRangeByScore get members of sorted set within score min and max (e.g. "-inf", "(10", "+inf").
+// Use count 0 to retrieve all members in range.
+func (cp *cachePipeline) ZRangeByScore(ctx context.Context, key, min, max string, offset, count int64) (res *StringSliceResult, err error) {
+
+	//skip wrong set
+	if key == "" || min == "" || max == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[ZRangeByScore]")
+	}
+
+	res = &StringSliceResult{pipelineResult{cp}, cp.pipe.ZRangeByScore(ctx, key, zRangeBy(min, max, offset, count))}
+	cp.cmdCount++
+	return
+}
+
+// ZRem delete members from sorted set
+func (cp *cachePipeline) ZRem(ctx context.Context, key string, members ...interface{}) (res *IntResult, err error) {
+
+	//skip wrong set
+	if key == "" || len(members) == 0 {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[ZRem]")
+	}
+
+	res = &IntResult{pipelineResult{cp}, cp.pipe.ZRem(ctx, key, members...)}
+	cp.cmdCount++
+	return
+}
+
+// SMembers get all members of set
+func (cp *cachePipeline) SMembers(ctx context.Context, key string) (res *StringSliceResult, err error) {
+
+	//skip wrong set
+	if key == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[SMembers]")
+	}
+
+	res = &StringSliceResult{pipelineResult{cp}, cp.pipe.SMembers(ctx, key)}
+	cp.cmdCount++
+	return
+}
+
+// SIsMember check whether member is part of set
+func (cp *cachePipeline) SIsMember(ctx context.Context, key string, member interface{}) (res *BoolResult, err error) {
+
+	//skip wrong set
+	if key == "" || member == nil {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[SIsMember]")
+	}
+
+	res = &BoolResult{pipelineResult{cp}, cp.pipe.SIsMember(ctx, key, member)}
+	cp.cmdCount++
+	return
+}
+
+// LPush prepend data to list
+func (cp *cachePipeline) LPush(ctx context.Context, key string, data ...interface{}) (res *IntResult, err error) {
+
+	//skip wrong set
+	if key == "" || len(data) == 0 {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[LPush]")
+	}
+
+	res = &IntResult{pipelineResult{cp}, cp.pipe.LPush(ctx, key, data...)}
+	cp.cmdCount++
+	return
+}
+
+// LPop remove and get the first element of list
+func (cp *cachePipeline) LPop(ctx context.Context, key string) (res *StringResult, err error) {
+
+	//skip wrong set
+	if key == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[LPop]")
+	}
+
+	res = &StringResult{pipelineResult{cp}, cp.pipe.LPop(ctx, key)}
+	cp.cmdCount++
+	return
+}
+
+// LTrim trim list to the specified range
+func (cp *cachePipeline) LTrim(ctx context.Context, key string, start, stop int64) (res *StatusResult, err error) {
+
+	//skip wrong set
+	if key == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[LTrim]")
+	}
+
+	res = &StatusResult{pipelineResult{cp}, cp.pipe.LTrim(ctx, key, start, stop)}
+	cp.cmdCount++
+	return
+}
+
+// HGet get a field from redis hash
+func (cp *cachePipeline) HGet(ctx context.Context, key, field string) (res *StringResult, err error) {
+
+	//skip wrong set
+	if key == "" || field == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[HGet]")
+	}
+
+	res = &StringResult{pipelineResult{cp}, cp.pipe.HGet(ctx, key, field)}
+	cp.cmdCount++
+	return
+}
+
+// HSet set a field of redis hash, the result is true when field is newly created
+func (cp *cachePipeline) HSet(ctx context.Context, key, field string, value interface{}) (res *BoolResult, err error) {
 
 	//skip wrong set
 	if key == "" || field == "" {
-		return errors.Wrap(ErrInvalidKeyorField, "[HSetNX]")
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[HSet]")
 	}
 
-	hSetNX := cp.pipe.HSetNX(key, field, value)
+	// HSET of single field replies 1 if the field is new, read it as bool
+	cmd := redis.NewBoolCmd(ctx, "hset", key, field, value)
+	cp.pipe.Process(ctx, cmd)
 
-	cp.boolCmds = append(cp.boolCmds, hSetNX)
+	res = &BoolResult{pipelineResult{cp}, cmd}
 	cp.cmdCount++
 	return
 }
 
+// Unlink delete keys from redis, the memory is reclaimed asynchronously by redis
+func (cp *cachePipeline) Unlink(ctx context.Context, keys ...string) (res *IntResult, err error) {
+
+	//skip wrong set
+	if len(keys) == 0 {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[Unlink]")
+	}
+
+	res = &IntResult{pipelineResult{cp}, cp.pipe.Unlink(ctx, keys...)}
+	cp.cmdCount++
+	return
+}
+
+// SetValue encode value with DefaultSerializer and set it to redis, use StringResult.Scan to read it back
+func (cp *cachePipeline) SetValue(ctx context.Context, key string, value interface{}, expire time.Duration) (res *StatusResult, err error) {
+
+	//skip wrong set
+	if key == "" || value == nil {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[SetValue]")
+	}
+
+	data, err := DefaultSerializer.Encode(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "[SetValue]")
+	}
+
+	res = &StatusResult{pipelineResult{cp}, cp.pipe.Set(ctx, key, data, expire)}
+	cp.cmdCount++
+	return
+}
+
+// HMSetStruct set struct fields into redis hash using cache tag, use StringMapResult.Scan to read it back
+func (cp *cachePipeline) HMSetStruct(ctx context.Context, key string, value interface{}) (res *StatusResult, err error) {
+
+	data, err := StructToHash(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "[HMSetStruct]")
+	}
+
+	return cp.HMSet(ctx, key, data)
+}
+
 // Hash return result with hash format (map[string]string)
 func Hash(source interface{}, fields []string) (result map[string]string, err error) {
 	result = make(map[string]string, 0)
@@ -0,0 +1,236 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+type (
+	// resulter is implemented by every typed pipeline result, it is used by the deprecated Get shim
+	resulter interface {
+		value() (interface{}, error)
+	}
+
+	// pipelineResult holds the pipeline that owns a command result
+	pipelineResult struct {
+		cp *cachePipeline
+	}
+
+	// StatusResult is a future for redis status reply (e.g. SET, MSET, HMSET)
+	StatusResult struct {
+		pipelineResult
+		cmd *redis.StatusCmd
+	}
+
+	// IntResult is a future for redis integer reply (e.g. DEL, RPUSH, SADD)
+	IntResult struct {
+		pipelineResult
+		cmd *redis.IntCmd
+	}
+
+	// StringResult is a future for redis bulk string reply (e.g. GETSET, LPOP, HGET)
+	StringResult struct {
+		pipelineResult
+		cmd *redis.StringCmd
+	}
+
+	// DurationResult is a future for redis duration reply (e.g. TTL)
+	DurationResult struct {
+		pipelineResult
+		cmd *redis.DurationCmd
+	}
+
+	// BoolResult is a future for redis boolean reply (e.g. EXPIRE, HSETNX)
+	BoolResult struct {
+		pipelineResult
+		cmd *redis.BoolCmd
+	}
+
+	// SliceResult is a future for redis multi value reply (e.g. MGET, HMGET)
+	SliceResult struct {
+		pipelineResult
+		cmd *redis.SliceCmd
+	}
+
+	// StringSliceResult is a future for redis list reply (e.g. LRANGE)
+	StringSliceResult struct {
+		pipelineResult
+		cmd *redis.StringSliceCmd
+	}
+
+	// StringMapResult is a future for redis hash reply (e.g. HGETALL)
+	StringMapResult struct {
+		pipelineResult
+		cmd *redis.MapStringStringCmd
+	}
+
+	// ScriptResult is a future for lua script reply (e.g. EVALSHA)
+	ScriptResult struct {
+		pipelineResult
+		cmd *redis.Cmd
+	}
+)
+
+// ready returns ErrNotExecuted when result is read before Exec is issued
+func (r pipelineResult) ready() error {
+	if r.cp == nil || !r.cp.didExec {
+		return ErrNotExecuted
+	}
+	return nil
+}
+
+// Result returns status reply of the command, it is only available after Exec
+func (r *StatusResult) Result() (string, error) {
+	if err := r.ready(); err != nil {
+		return "", errors.Wrap(err, "[StatusResult]")
+	}
+	return r.cmd.Result()
+}
+
+func (r *StatusResult) value() (interface{}, error) {
+	return r.Result()
+}
+
+// Result returns integer reply of the command, it is only available after Exec
+func (r *IntResult) Result() (int64, error) {
+	if err := r.ready(); err != nil {
+		return 0, errors.Wrap(err, "[IntResult]")
+	}
+	return r.cmd.Result()
+}
+
+func (r *IntResult) value() (interface{}, error) {
+	return r.Result()
+}
+
+// Result returns bulk string reply of the command, ErrCacheMiss is returned when the key or field does not exist.
+// It is only available after Exec
+func (r *StringResult) Result() (string, error) {
+	if err := r.ready(); err != nil {
+		return "", errors.Wrap(err, "[StringResult]")
+	}
+	val, err := r.cmd.Result()
+	if err == redis.Nil {
+		return "", ErrCacheMiss
+	}
+	return val, err
+}
+
+func (r *StringResult) value() (interface{}, error) {
+	return r.Result()
+}
+
+// Scan decode value written by SetValue into dst
+func (r *StringResult) Scan(dst interface{}) error {
+	data, err := r.Result()
+	if err != nil {
+		return err
+	}
+	return Decode([]byte(data), dst)
+}
+
+// Result returns remaining time to live of the key, negative duration is returned when the key does not exist or has no expire.
+// It is only available after Exec
+func (r *DurationResult) Result() (time.Duration, error) {
+	if err := r.ready(); err != nil {
+		return 0, errors.Wrap(err, "[DurationResult]")
+	}
+	return r.cmd.Result()
+}
+
+func (r *DurationResult) value() (interface{}, error) {
+	return r.Result()
+}
+
+// Result returns boolean reply of the command, it is only available after Exec
+func (r *BoolResult) Result() (bool, error) {
+	if err := r.ready(); err != nil {
+		return false, errors.Wrap(err, "[BoolResult]")
+	}
+	return r.cmd.Result()
+}
+
+func (r *BoolResult) value() (interface{}, error) {
+	return r.Result()
+}
+
+// Result returns multi value reply of the command, missing value is returned as nil.
+// It is only available after Exec
+func (r *SliceResult) Result() ([]interface{}, error) {
+	if err := r.ready(); err != nil {
+		return nil, errors.Wrap(err, "[SliceResult]")
+	}
+	return r.cmd.Result()
+}
+
+func (r *SliceResult) value() (interface{}, error) {
+	return r.Result()
+}
+
+// Result returns list reply of the command, it is only available after Exec
+func (r *StringSliceResult) Result() ([]string, error) {
+	if err := r.ready(); err != nil {
+		return nil, errors.Wrap(err, "[StringSliceResult]")
+	}
+	return r.cmd.Result()
+}
+
+func (r *StringSliceResult) value() (interface{}, error) {
+	return r.Result()
+}
+
+// Result returns hash reply of the command, it is only available after Exec
+func (r *StringMapResult) Result() (map[string]string, error) {
+	if err := r.ready(); err != nil {
+		return nil, errors.Wrap(err, "[StringMapResult]")
+	}
+	return r.cmd.Result()
+}
+
+func (r *StringMapResult) value() (interface{}, error) {
+	return r.Result()
+}
+
+// Scan fill struct pointed by dst from hash reply using cache tag (see HashToStruct).
+// ErrCacheMiss is returned when the hash does not exist
+func (r *StringMapResult) Scan(dst interface{}) error {
+	data, err := r.Result()
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return ErrCacheMiss
+	}
+	return HashToStruct(data, dst)
+}
+
+// Result returns reply of the script, nil reply is returned as nil result without error.
+// It is only available after Exec
+func (r *ScriptResult) Result() (interface{}, error) {
+	if err := r.ready(); err != nil {
+		return nil, errors.Wrap(err, "[ScriptResult]")
+	}
+	val, err := r.cmd.Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return val, err
+}
+
+// Int64 returns integer reply of the script, it is only available after Exec
+func (r *ScriptResult) Int64() (int64, error) {
+	if err := r.ready(); err != nil {
+		return 0, errors.Wrap(err, "[ScriptResult]")
+	}
+	return r.cmd.Int64()
+}
+
+// Text returns string reply of the script, it is only available after Exec
+func (r *ScriptResult) Text() (string, error) {
+	if err := r.ready(); err != nil {
+		return "", errors.Wrap(err, "[ScriptResult]")
+	}
+	return r.cmd.Text()
+}
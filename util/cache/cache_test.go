@@ -0,0 +1,627 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pkg/errors"
+
+	redisclient "github.com/golang-base-template/util/cache/client"
+)
+
+// newTestRedis points CacheGBT connection to a fresh miniredis, it has no script loaded
+func newTestRedis(t *testing.T) *miniredis.Miniredis {
+	mr := miniredis.RunT(t)
+	unloadScripts(CacheGBT)
+	redisclient.RedisClients = redisclient.RedisConnsMap{
+		CacheGBT: redisclient.NewConnection(CacheGBT, mr.Addr(), ""),
+	}
+	return mr
+}
+
+func TestCacheCommands(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		prepare func(mr *miniredis.Miniredis)
+		run     func(c ICache) (interface{}, error)
+		want    interface{}
+		wantErr error
+	}{
+		{
+			name: "Get miss",
+			run: func(c ICache) (interface{}, error) {
+				return c.Get(ctx, "gbt:k")
+			},
+			want:    "",
+			wantErr: ErrCacheMiss,
+		},
+		{
+			name: "Get empty key",
+			run: func(c ICache) (interface{}, error) {
+				return c.Get(ctx, "")
+			},
+			want:    "",
+			wantErr: ErrInvalidKeyorField,
+		},
+		{
+			name: "GetSet miss",
+			run: func(c ICache) (interface{}, error) {
+				return c.GetSet(ctx, "gbt:k", "v")
+			},
+			want:    "",
+			wantErr: ErrCacheMiss,
+		},
+		{
+			name: "Incr",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:k", "1")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.Incr(ctx, "gbt:k")
+			},
+			want: int64(2),
+		},
+		{
+			name: "Incr empty key",
+			run: func(c ICache) (interface{}, error) {
+				return c.Incr(ctx, "")
+			},
+			want:    int64(0),
+			wantErr: ErrInvalidKeyorField,
+		},
+		{
+			name: "IncrBy",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:k", "1")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.IncrBy(ctx, "gbt:k", 5)
+			},
+			want: int64(6),
+		},
+		{
+			name: "Expire",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:k", "v")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.Expire(ctx, "gbt:k", time.Minute)
+			},
+			want: true,
+		},
+		{
+			name: "Expire miss",
+			run: func(c ICache) (interface{}, error) {
+				return c.Expire(ctx, "gbt:k", time.Minute)
+			},
+			want: false,
+		},
+		{
+			name: "TTL",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:k", "v")
+				mr.SetTTL("gbt:k", time.Minute)
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.TTL(ctx, "gbt:k")
+			},
+			want: time.Minute,
+		},
+		{
+			name: "TTL empty key",
+			run: func(c ICache) (interface{}, error) {
+				return c.TTL(ctx, "")
+			},
+			want:    time.Duration(0),
+			wantErr: ErrInvalidKeyorField,
+		},
+		{
+			name: "Exists",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:k", "v")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.Exists(ctx, "gbt:k")
+			},
+			want: true,
+		},
+		{
+			name: "Exists miss",
+			run: func(c ICache) (interface{}, error) {
+				return c.Exists(ctx, "gbt:k")
+			},
+			want: false,
+		},
+		{
+			name: "HSetNX",
+			run: func(c ICache) (interface{}, error) {
+				return c.HSetNX(ctx, "gbt:h", "f", "v")
+			},
+			want: true,
+		},
+		{
+			name: "HSetNX existing field",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.HSet("gbt:h", "f", "v")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.HSetNX(ctx, "gbt:h", "f", "w")
+			},
+			want: false,
+		},
+		{
+			name: "HSetNX empty field",
+			run: func(c ICache) (interface{}, error) {
+				return c.HSetNX(ctx, "gbt:h", "", "v")
+			},
+			want:    false,
+			wantErr: ErrInvalidKeyorField,
+		},
+		{
+			name: "HGet miss",
+			run: func(c ICache) (interface{}, error) {
+				return c.HGet(ctx, "gbt:h", "f")
+			},
+			want:    "",
+			wantErr: ErrCacheMiss,
+		},
+		{
+			name: "HIncrBy",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.HSet("gbt:h", "f", "2")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.HIncrBy(ctx, "gbt:h", "f", 3)
+			},
+			want: int64(5),
+		},
+		{
+			name: "SAdd",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.SetAdd("gbt:s", "a")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.SAdd(ctx, "gbt:s", "a", "b")
+			},
+			want: int64(1),
+		},
+		{
+			name: "SAdd without member",
+			run: func(c ICache) (interface{}, error) {
+				return c.SAdd(ctx, "gbt:s")
+			},
+			want:    int64(0),
+			wantErr: ErrInvalidKeyorField,
+		},
+		{
+			name: "SMembers",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.SetAdd("gbt:s", "b", "a")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.SMembers(ctx, "gbt:s")
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "SIsMember",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.SetAdd("gbt:s", "a")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.SIsMember(ctx, "gbt:s", "a")
+			},
+			want: true,
+		},
+		{
+			name: "LPush",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Push("gbt:l", "a")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.LPush(ctx, "gbt:l", "b", "c")
+			},
+			want: int64(3),
+		},
+		{
+			name: "ZRangeByScore all",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.ZAdd("gbt:z", 1, "a")
+				mr.ZAdd("gbt:z", 2, "b")
+				mr.ZAdd("gbt:z", 3, "c")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.ZRangeByScore(ctx, "gbt:z", "-inf", "+inf", 0, 0)
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "ZRangeByScore offset without count",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.ZAdd("gbt:z", 1, "a")
+				mr.ZAdd("gbt:z", 2, "b")
+				mr.ZAdd("gbt:z", 3, "c")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.ZRangeByScore(ctx, "gbt:z", "-inf", "+inf", 1, 0)
+			},
+			want: []string{"b", "c"},
+		},
+		{
+			name: "ZRangeByScore offset and count",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.ZAdd("gbt:z", 1, "a")
+				mr.ZAdd("gbt:z", 2, "b")
+				mr.ZAdd("gbt:z", 3, "c")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.ZRangeByScore(ctx, "gbt:z", "(1", "+inf", 1, 1)
+			},
+			want: []string{"c"},
+		},
+		{
+			name: "LRange",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Push("gbt:l", "a", "b", "c")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.LRange(ctx, "gbt:l", 0, 1)
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "LPop",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Push("gbt:l", "a", "b")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.LPop(ctx, "gbt:l")
+			},
+			want: "a",
+		},
+		{
+			name: "LPop empty list",
+			run: func(c ICache) (interface{}, error) {
+				return c.LPop(ctx, "gbt:l")
+			},
+			want:    "",
+			wantErr: ErrCacheMiss,
+		},
+		{
+			name: "Unlink",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:a", "1")
+				mr.Set("gbt:b", "1")
+			},
+			run: func(c ICache) (interface{}, error) {
+				return c.Unlink(ctx, "gbt:a", "gbt:b", "gbt:c")
+			},
+			want: int64(2),
+		},
+		{
+			name: "Unlink without key",
+			run: func(c ICache) (interface{}, error) {
+				return c.Unlink(ctx)
+			},
+			want:    int64(0),
+			wantErr: ErrInvalidKeyorField,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestRedis(t)
+			if tt.prepare != nil {
+				tt.prepare(mr)
+			}
+
+			got, err := tt.run(NewCache())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheNoConnection(t *testing.T) {
+	_, err := NewCache("redis-unknown").Incr(context.Background(), "gbt:k")
+	if !errors.Is(err, ErrConn) {
+		t.Errorf("err = %v, want %v", err, ErrConn)
+	}
+}
+
+func TestPipelineCommands(t *testing.T) {
+	ctx := context.Background()
+
+	// queue queues the command and return its result reader, result is read after Exec
+	type queue func(p ICachePipeline) (func() (interface{}, error), error)
+
+	tests := []struct {
+		name     string
+		prepare  func(mr *miniredis.Miniredis)
+		queue    queue
+		want     interface{}
+		wantErr  error
+		queueErr error
+	}{
+		{
+			name: "Incr",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:k", "1")
+			},
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.Incr(ctx, "gbt:k")
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want: int64(2),
+		},
+		{
+			name: "Incr empty key",
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				_, err := p.Incr(ctx, "")
+				return nil, err
+			},
+			queueErr: ErrInvalidKeyorField,
+		},
+		{
+			name: "IncrBy",
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.IncrBy(ctx, "gbt:k", 4)
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want: int64(4),
+		},
+		{
+			name: "Expire",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:k", "v")
+			},
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.Expire(ctx, "gbt:k", time.Minute)
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want: true,
+		},
+		{
+			name: "TTL",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:k", "v")
+				mr.SetTTL("gbt:k", time.Minute)
+			},
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.TTL(ctx, "gbt:k")
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want: time.Minute,
+		},
+		{
+			name: "Exists miss",
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.Exists(ctx, "gbt:k")
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want: false,
+		},
+		{
+			name: "HSetNX",
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.HSetNX(ctx, "gbt:h", "f", "v")
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want: true,
+		},
+		{
+			name: "HSetNX empty key",
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				_, err := p.HSetNX(ctx, "", "f", "v")
+				return nil, err
+			},
+			queueErr: ErrInvalidKeyorField,
+		},
+		{
+			name: "HGet miss",
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.HGet(ctx, "gbt:h", "f")
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want:    "",
+			wantErr: ErrCacheMiss,
+		},
+		{
+			name: "HIncrBy",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.HSet("gbt:h", "f", "2")
+			},
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.HIncrBy(ctx, "gbt:h", "f", -1)
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want: int64(1),
+		},
+		{
+			name: "SAdd",
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.SAdd(ctx, "gbt:s", "a", "b")
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want: int64(2),
+		},
+		{
+			name: "SMembers",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.SetAdd("gbt:s", "b", "a")
+			},
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.SMembers(ctx, "gbt:s")
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "LPush",
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.LPush(ctx, "gbt:l", "a", "b")
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want: int64(2),
+		},
+		{
+			name: "ZRangeByScore offset without count",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.ZAdd("gbt:z", 1, "a")
+				mr.ZAdd("gbt:z", 2, "b")
+				mr.ZAdd("gbt:z", 3, "c")
+			},
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.ZRangeByScore(ctx, "gbt:z", "-inf", "+inf", 2, 0)
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want: []string{"c"},
+		},
+		{
+			name: "LRange",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Push("gbt:l", "a", "b", "c")
+			},
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.LRange(ctx, "gbt:l", 1, -1)
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want: []string{"b", "c"},
+		},
+		{
+			name: "LPop empty list",
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.LPop(ctx, "gbt:l")
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want:    "",
+			wantErr: ErrCacheMiss,
+		},
+		{
+			name: "Unlink",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:a", "1")
+			},
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.Unlink(ctx, "gbt:a", "gbt:b")
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want: int64(1),
+		},
+		{
+			name: "Unlink without key",
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				_, err := p.Unlink(ctx)
+				return nil, err
+			},
+			queueErr: ErrInvalidKeyorField,
+		},
+		{
+			name: "GetSet miss",
+			queue: func(p ICachePipeline) (func() (interface{}, error), error) {
+				res, err := p.GetSet(ctx, "gbt:k", "v")
+				return func() (interface{}, error) { return res.Result() }, err
+			},
+			want:    "",
+			wantErr: ErrCacheMiss,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestRedis(t)
+			if tt.prepare != nil {
+				tt.prepare(mr)
+			}
+
+			p, err := NewPkgPipeline().NewPipeline(ctx)
+			if err != nil {
+				t.Fatalf("NewPipeline err = %v", err)
+			}
+
+			result, err := tt.queue(p)
+			if !errors.Is(err, tt.queueErr) {
+				t.Fatalf("queue err = %v, want %v", err, tt.queueErr)
+			}
+			if tt.queueErr != nil {
+				return
+			}
+
+			if _, err := result(); !errors.Is(err, ErrNotExecuted) {
+				t.Errorf("result before Exec err = %v, want %v", err, ErrNotExecuted)
+			}
+			if err := p.Exec(ctx); err != nil {
+				t.Fatalf("Exec err = %v", err)
+			}
+
+			got, err := result()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipelineNoConnection(t *testing.T) {
+	_, err := NewPkgPipeline("redis-unknown").NewPipeline(context.Background())
+	if !errors.Is(err, ErrConn) {
+		t.Errorf("err = %v, want %v", err, ErrConn)
+	}
+}
+
+func TestPipelineDegradeNoop(t *testing.T) {
+	ctx := context.Background()
+	mr := newTestRedis(t)
+	redisclient.RedisClients[CacheGBT].Degrade = redisclient.DegradeNoop
+
+	// consecutive connection failures open the circuit breaker
+	mr.Close()
+	c := NewCache()
+	for i := 0; i < 10 && redisclient.IsAvailable(CacheGBT); i++ {
+		c.Get(ctx, "gbt:k")
+	}
+	if redisclient.IsAvailable(CacheGBT) {
+		t.Fatal("circuit breaker is not open")
+	}
+
+	p, err := NewPkgPipeline().NewPipeline(ctx)
+	if err != nil {
+		t.Fatalf("NewPipeline err = %v", err)
+	}
+	get, _ := p.HGet(ctx, "gbt:h", "f")
+	set, _ := p.Set(ctx, "gbt:k", "v", time.Minute)
+	del, _ := p.Del(ctx, "gbt:k")
+	incr, _ := p.Incr(ctx, "gbt:counter")
+	setNX, _ := p.SetNX(ctx, "gbt:lock", "owner", time.Minute)
+
+	if err := p.Exec(ctx); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Exec err = %v, want %v", err, ErrCircuitOpen)
+	}
+	if _, err := get.Result(); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("HGet err = %v, want %v", err, ErrCacheMiss)
+	}
+	if _, err := set.Result(); err != nil {
+		t.Errorf("Set err = %v, want dropped", err)
+	}
+	if _, err := del.Result(); err != nil {
+		t.Errorf("Del err = %v, want dropped", err)
+	}
+	if _, err := incr.Result(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Incr err = %v, want %v", err, ErrCircuitOpen)
+	}
+	if _, err := setNX.Result(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("SetNX err = %v, want %v", err, ErrCircuitOpen)
+	}
+}
@@ -0,0 +1,344 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// degrade mode, see RedisConf Degrade
+const (
+	// DegradeError makes pipeline fail with ErrCircuitOpen while redis is unavailable
+	DegradeError = "error"
+	// DegradeNoop makes pipeline read a cache miss and drop fire-and-forget write while redis is unavailable
+	DegradeNoop = "noop"
+)
+
+// circuit breaker state
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = time.Second
+	healthCheckInterval     = time.Second
+	healthCheckTimeout      = 500 * time.Millisecond
+)
+
+type (
+	// Health is health state of a redis connection
+	Health struct {
+		Up bool
+		// Breaker is circuit breaker state, either "closed", "open" or "half-open"
+		Breaker string
+		// Failures is number of consecutive connection failures
+		Failures  int
+		LastError error
+		LastCheck time.Time
+	}
+
+	// breaker opens after consecutive connection failures so commands fail fast while redis is down.
+	// After cooldown, a single probe command is let through (half-open) and its result closes or reopens the breaker.
+	breaker struct {
+		threshold int
+		cooldown  time.Duration
+
+		mx        sync.Mutex
+		state     string
+		failures  int
+		openedAt  time.Time
+		probing   bool
+		lastErr   error
+		lastCheck time.Time
+	}
+
+	// breakerHook rejects commands while the breaker is open and reports command result to the breaker
+	breakerHook struct {
+		breaker *breaker
+	}
+
+	// healthCheckKey marks ctx of health check ping, its deadline is set by the breaker instead of a caller
+	healthCheckKey struct{}
+)
+
+var (
+	// ErrCircuitOpen is returned by every command while circuit breaker of the connection is open
+	ErrCircuitOpen = errors.New("redis: circuit breaker is open")
+
+	// degradeReadCommands are commands replying stored value, they are read as cache miss on noop degrade
+	// instead of returning zero value, it is separate from readCommands counted as cache hit or miss
+	degradeReadCommands = map[string]bool{
+		"get":           true,
+		"getset":        true,
+		"getdel":        true,
+		"mget":          true,
+		"strlen":        true,
+		"hget":          true,
+		"hmget":         true,
+		"hgetall":       true,
+		"hexists":       true,
+		"hkeys":         true,
+		"hvals":         true,
+		"hlen":          true,
+		"ttl":           true,
+		"pttl":          true,
+		"exists":        true,
+		"type":          true,
+		"smembers":      true,
+		"sismember":     true,
+		"scard":         true,
+		"spop":          true,
+		"lrange":        true,
+		"lindex":        true,
+		"llen":          true,
+		"lpop":          true,
+		"rpop":          true,
+		"zrange":        true,
+		"zrangebyscore": true,
+		"zrevrange":     true,
+		"zscore":        true,
+		"zcard":         true,
+		"zrank":         true,
+	}
+
+	// degradeDropCommands are fire-and-forget writes, they are dropped on noop degrade. Other commands
+	// (e.g. INCR, SETNX) keep ErrCircuitOpen since the caller acts on their reply
+	degradeDropCommands = map[string]bool{
+		"set":      true,
+		"setex":    true,
+		"psetex":   true,
+		"mset":     true,
+		"del":      true,
+		"unlink":   true,
+		"expire":   true,
+		"pexpire":  true,
+		"expireat": true,
+		"hset":     true,
+		"hmset":    true,
+		"hdel":     true,
+	}
+)
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		threshold = defaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+// allow reports whether a command can be sent to redis
+func (b *breaker) allow() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		// only one probe at a time
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// done reports command result, only connection failure (e.g. dial error, timeout) counts as failure.
+// Command whose ctx is cancelled or passes its deadline is not counted, the caller gave up rather than redis,
+// except health check ping whose deadline is the breaker own timeout.
+func (b *breaker) done(ctx context.Context, err error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.probing = false
+	failed := isConnError(err)
+	if ctx.Value(healthCheckKey{}) != nil {
+		failed = failed || errors.Is(err, context.DeadlineExceeded)
+	} else if ctx.Err() != nil {
+		return
+	}
+	if !failed {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastErr = err
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		if b.state != BreakerOpen {
+			log.Printf("[Redis][Breaker] circuit breaker is open after %d failures, err: %v", b.failures, err)
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *breaker) health() Health {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	return Health{
+		Up:        b.state == BreakerClosed && b.failures == 0,
+		Breaker:   b.state,
+		Failures:  b.failures,
+		LastError: b.lastErr,
+		LastCheck: b.lastCheck,
+	}
+}
+
+// isConnError reports whether err is caused by unavailable redis rather than the command or its caller,
+// i.e. network error including dial failure and client read/write timeout, or connection closed by redis.
+// Error reply (e.g. WRONGTYPE) means redis is up.
+func isConnError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func (h breakerHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h breakerHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !h.breaker.allow() {
+			return ErrCircuitOpen
+		}
+
+		err := next(ctx, cmd)
+		h.breaker.done(ctx, err)
+		return err
+	}
+}
+
+func (h breakerHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if !h.breaker.allow() {
+			for _, cmd := range cmds {
+				cmd.SetErr(ErrCircuitOpen)
+			}
+			return ErrCircuitOpen
+		}
+
+		err := next(ctx, cmds)
+		h.breaker.done(ctx, err)
+		return err
+	}
+}
+
+// GetHealth return health state of the connection
+func GetHealth(connString string) (Health, error) {
+	mxRc.Lock()
+	conn, ok := RedisClients[connString]
+	mxRc.Unlock()
+	if !ok || conn == nil {
+		return Health{}, errors.New("[GetHealth] Redis connection " + connString + " doesn't exist")
+	}
+
+	return conn.breaker.health(), nil
+}
+
+// IsAvailable reports whether commands of the connection are sent to redis, i.e. its circuit breaker is not open
+func IsAvailable(connString string) bool {
+	health, err := GetHealth(connString)
+	return err == nil && health.Breaker != BreakerOpen
+}
+
+// DegradeMode return pipeline behavior of the connection while redis is unavailable
+func DegradeMode(connString string) string {
+	mxRc.Lock()
+	defer mxRc.Unlock()
+
+	if conn, ok := RedisClients[connString]; ok && conn.Degrade == DegradeNoop {
+		return DegradeNoop
+	}
+	return DegradeError
+}
+
+// IsReadCommand reports whether the command replies stored value (e.g. GET, TTL, EXISTS, LPOP),
+// such command is read as cache miss on noop degrade
+func IsReadCommand(name string) bool {
+	return degradeReadCommands[name]
+}
+
+// IsDropCommand reports whether the command is a write whose reply is not acted on (e.g. SET, DEL, EXPIRE, HSET),
+// such command is dropped on noop degrade. SET with NX, XX or GET option is not, its reply tells what happened.
+func IsDropCommand(name string, args []interface{}) bool {
+	if !degradeDropCommands[name] {
+		return false
+	}
+	if name == "set" {
+		for i := 1; i < len(args); i++ {
+			if s, ok := args[i].(string); ok {
+				switch strings.ToLower(s) {
+				case "nx", "xx", "get":
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// healthCheck pings every connection periodically so its breaker is closed as soon as redis is reachable again,
+// the client itself redials broken connections on next command.
+func healthCheck() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		mxRc.Lock()
+		conns := make(map[string]*RedisConnInfo, len(RedisClients))
+		for name, conn := range RedisClients {
+			conns[name] = conn
+		}
+		mxRc.Unlock()
+
+		for name, conn := range conns {
+			ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), healthCheckKey{}, true), healthCheckTimeout)
+			before := conn.breaker.health()
+			err := conn.Conn.Ping(ctx).Err()
+			cancel()
+
+			conn.breaker.mx.Lock()
+			conn.breaker.lastCheck = time.Now()
+			conn.breaker.mx.Unlock()
+
+			if err == nil && !before.Up {
+				log.Printf("[Redis][healthCheck] redis %s is reconnected", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/golang-base-template/util/metrics"
+)
+
+const (
+	// maxKeyPrefixSegments is the maximum number of key segments kept by DefaultKeyPrefix
+	maxKeyPrefixSegments = 3
+)
+
+type (
+	// metricsHook records latency, error and hit/miss of every command and pipeline of a connection
+	metricsHook struct {
+		conn string
+	}
+)
+
+var (
+	// KeyPrefix groups key into low cardinality prefix for hit/miss metrics, override it to match the app key format
+	KeyPrefix = DefaultKeyPrefix
+
+	// readCommands are GET-style commands counted as cache hit or miss
+	readCommands = map[string]bool{
+		"get":     true,
+		"getset":  true,
+		"mget":    true,
+		"hget":    true,
+		"hmget":   true,
+		"hgetall": true,
+	}
+)
+
+// DefaultKeyPrefix keeps leading colon separated segments of the key until a segment containing digit,
+// and never keeps the last segment of multi segment key, e.g. "gbt:employee:123" is grouped as "gbt:employee"
+func DefaultKeyPrefix(key string) string {
+	segments := strings.Split(key, ":")
+	if len(segments) == 1 {
+		return segments[0]
+	}
+
+	n := 1
+	for n < len(segments)-1 && n < maxKeyPrefixSegments && !strings.ContainsAny(segments[n], "0123456789{") {
+		n++
+	}
+	return strings.Join(segments[:n], ":")
+}
+
+func (h metricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h metricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+
+		metrics.ObserveDuration("cache.command.latency", time.Since(start), h.conn, cmd.Name())
+		// command error is only set to cmd after every hook returns
+		h.record(cmd, err)
+		return err
+	}
+}
+
+func (h metricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+
+		metrics.ObserveDuration("cache.pipeline.latency", time.Since(start), h.conn)
+		for _, cmd := range cmds {
+			h.record(cmd, cmd.Err())
+		}
+		return err
+	}
+}
+
+// record counts error and hit/miss of executed command
+func (h metricsHook) record(cmd redis.Cmder, err error) {
+	if err != nil && err != redis.Nil {
+		metrics.IncrCounter("cache.command.errors", 1, h.conn, cmd.Name())
+		return
+	}
+
+	if !readCommands[cmd.Name()] || len(cmd.Args()) < 2 {
+		return
+	}
+
+	key, _ := cmd.Args()[1].(string)
+	prefix := KeyPrefix(key)
+
+	var hit, miss int64
+	switch c := cmd.(type) {
+	case *redis.SliceCmd:
+		// mget and hmget reply nil for each missing key or field
+		for _, v := range c.Val() {
+			if v == nil {
+				miss++
+			} else {
+				hit++
+			}
+		}
+	case *redis.MapStringStringCmd:
+		if len(c.Val()) == 0 {
+			miss++
+		} else {
+			hit++
+		}
+	default:
+		if err == redis.Nil {
+			miss++
+		} else {
+			hit++
+		}
+	}
+
+	if hit > 0 {
+		metrics.IncrCounter("cache.hit", hit, h.conn, prefix)
+	}
+	if miss > 0 {
+		metrics.IncrCounter("cache.miss", miss, h.conn, prefix)
+	}
+}
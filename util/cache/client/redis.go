@@ -1,17 +1,34 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/pkg/errors"
-	"gopkg.in/redis.v5"
+	"github.com/redis/go-redis/v9"
 
 	"github.com/golang-base-template/util/config"
 )
 
+// redis topology, see RedisConf Mode
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
 type (
+	// Redis is common interface of standalone, sentinel and cluster client,
+	// so pipeline and other helpers work against any topology.
+	// Note that multi key command (e.g. MGet, MSet, Del) on cluster requires all keys in the same hash slot.
+	Redis interface {
+		redis.UniversalClient
+	}
+
 	//RedisConnsMap holds maps of redis connections
 	RedisConnsMap map[string]*RedisConnInfo
 
@@ -19,7 +36,12 @@ type (
 	RedisConnInfo struct {
 		Addr string
 		Pass string
-		Conn *redis.Client
+		Mode string
+		Conn Redis
+		// Degrade is pipeline behavior while redis is unavailable, either "error" (default) or "noop"
+		Degrade string
+
+		breaker *breaker
 	}
 
 	RedisList struct {
@@ -38,57 +60,76 @@ var (
 	mxRc           = sync.Mutex{}
 )
 
+// InitRedis connects to given redis connections and pings them.
+// Connection is kept even if its ping fails, it is reconnected in background once redis is reachable.
 func InitRedis(connString []string) (err error) {
+	connList := config.Get().Redis
+
+	// previous connections are closed once replaced, so re-init does not leak their pools
+	replaced := make(map[string]*RedisConnInfo)
+	mxRc.Lock()
 	if RedisClients == nil {
 		RedisClients = make(map[string]*RedisConnInfo)
 	}
-	connList := config.Get().Redis
 	for _, name := range connString {
 		addstruct, exist := connList[name]
 		if !exist {
 			continue
 		}
-		RedisClients[name] = NewConnection(name, addstruct.Address, "")
+		if old, ok := RedisClients[name]; ok && old != nil && old.Conn != nil {
+			replaced[name] = old
+		}
+		RedisClients[name] = NewConnection(name, addstruct.Address, addstruct.Password)
+	}
+	mxRc.Unlock()
+
+	for name, old := range replaced {
+		if errClose := old.Conn.Close(); errClose != nil {
+			err = errors.Wrapf(errClose, "[InitRedis] fail to close previous %s", name)
+		}
 	}
 
-	err = pingRedis()
+	onReconnect.Do(func() {
+		go healthCheck()
+	})
+
+	if errPing := pingRedis(connString); errPing != nil {
+		err = errPing
+	}
 
 	return
 }
 
 // NewConnection for given connection string.
+// Topology, DB and tls are taken from redis config of given name, connection is used as standalone address
+// and password overrides the configured one if not empty.
 func NewConnection(name, connection, password string) *RedisConnInfo {
 	// init with default config
-	redisOpt := &redis.Options{
-		Addr:               connection,
-		Password:           password,
-		DB:                 0,
-		PoolSize:           500,
-		MaxRetries:         2,
-		PoolTimeout:        time.Millisecond * 1200,
-		DialTimeout:        time.Millisecond * 1000,
-		ReadTimeout:        time.Millisecond * 1000,
-		WriteTimeout:       time.Millisecond * 1000,
-		IdleTimeout:        time.Second * 60,
-		IdleCheckFrequency: time.Second * 10,
-	}
-
-	if rdsConfig, exist := config.Get().Redis[name]; exist {
-		redisOpt = &redis.Options{
-			Addr:               connection,
-			Password:           password, // set password if gcp environment, no need for aliyun
-			DB:                 0,        // use default DB
-			PoolSize:           rdsConfig.PoolSize,
-			MaxRetries:         2,
-			PoolTimeout:        time.Millisecond * time.Duration(rdsConfig.PoolTimeoutMS),
-			DialTimeout:        time.Millisecond * time.Duration(rdsConfig.DialTimeoutMS),
-			ReadTimeout:        time.Millisecond * time.Duration(rdsConfig.ReadTimeoutMS),
-			WriteTimeout:       time.Millisecond * time.Duration(rdsConfig.WriteTimeoutMS),
-			IdleTimeout:        time.Second * time.Duration(rdsConfig.IdleTimeoutSec),
-			IdleCheckFrequency: time.Second * time.Duration(rdsConfig.IdleFreqCheckSec),
-		}
+	rdsConfig := &config.RedisConf{
+		PoolSize:         500,
+		PoolTimeoutMS:    1200,
+		DialTimeoutMS:    1000,
+		ReadTimeoutMS:    1000,
+		WriteTimeoutMS:   1000,
+		IdleTimeoutSec:   60,
+		IdleFreqCheckSec: 10,
+	}
+	if conf, exist := config.Get().Redis[name]; exist && conf != nil {
+		rdsConfig = conf
+	}
+	if password == "" {
+		password = rdsConfig.Password
+	}
+
+	var rds Redis
+	switch rdsConfig.Mode {
+	case ModeSentinel:
+		rds = newFailoverClient(rdsConfig, password)
+	case ModeCluster:
+		rds = newClusterClient(rdsConfig, connection, password)
+	default:
+		rds = newClient(rdsConfig, connection, password)
 	}
-	rds := redis.NewClient(redisOpt)
 
 	// mock redis in unit test
 	if isUnitTest {
@@ -97,16 +138,95 @@ func NewConnection(name, connection, password string) *RedisConnInfo {
 			Addr: mr.Addr(),
 		})
 	}
+	cb := newBreaker(rdsConfig.BreakerThreshold, time.Millisecond*time.Duration(rdsConfig.BreakerCooldownMS))
+	rds.AddHook(timeoutHook{})
+	rds.AddHook(metricsHook{conn: name})
+	rds.AddHook(breakerHook{breaker: cb})
 
 	return &RedisConnInfo{
-		Addr: connection,
-		Pass: password,
-		Conn: rds,
+		Addr:    connection,
+		Pass:    password,
+		Mode:    rdsConfig.Mode,
+		Conn:    rds,
+		Degrade: rdsConfig.Degrade,
+		breaker: cb,
+	}
+}
+
+// newClient return single node client
+func newClient(rdsConfig *config.RedisConf, connection, password string) *redis.Client {
+	if connection == "" {
+		connection = rdsConfig.Address
+	}
+
+	return redis.NewClient(&redis.Options{
+		Addr:                  connection,
+		Password:              password,
+		DB:                    rdsConfig.DB,
+		PoolSize:              rdsConfig.PoolSize,
+		MaxRetries:            2,
+		PoolTimeout:           time.Millisecond * time.Duration(rdsConfig.PoolTimeoutMS),
+		DialTimeout:           time.Millisecond * time.Duration(rdsConfig.DialTimeoutMS),
+		ReadTimeout:           time.Millisecond * time.Duration(rdsConfig.ReadTimeoutMS),
+		WriteTimeout:          time.Millisecond * time.Duration(rdsConfig.WriteTimeoutMS),
+		ConnMaxIdleTime:       time.Second * time.Duration(rdsConfig.IdleTimeoutSec),
+		TLSConfig:             tlsConfig(rdsConfig),
+		ContextTimeoutEnabled: true,
+	})
+}
+
+// newFailoverClient return client of master monitored by sentinel
+func newFailoverClient(rdsConfig *config.RedisConf, password string) *redis.Client {
+	return redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:            rdsConfig.MasterName,
+		SentinelAddrs:         rdsConfig.Addresses,
+		Password:              password,
+		DB:                    rdsConfig.DB,
+		PoolSize:              rdsConfig.PoolSize,
+		MaxRetries:            2,
+		PoolTimeout:           time.Millisecond * time.Duration(rdsConfig.PoolTimeoutMS),
+		DialTimeout:           time.Millisecond * time.Duration(rdsConfig.DialTimeoutMS),
+		ReadTimeout:           time.Millisecond * time.Duration(rdsConfig.ReadTimeoutMS),
+		WriteTimeout:          time.Millisecond * time.Duration(rdsConfig.WriteTimeoutMS),
+		ConnMaxIdleTime:       time.Second * time.Duration(rdsConfig.IdleTimeoutSec),
+		TLSConfig:             tlsConfig(rdsConfig),
+		ContextTimeoutEnabled: true,
+	})
+}
+
+// newClusterClient return cluster client, connection is used as seed node if no addresses configured
+func newClusterClient(rdsConfig *config.RedisConf, connection, password string) *redis.ClusterClient {
+	addrs := rdsConfig.Addresses
+	if len(addrs) == 0 && connection != "" {
+		addrs = []string{connection}
+	}
+
+	return redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:                 addrs,
+		Password:              password,
+		PoolSize:              rdsConfig.PoolSize,
+		PoolTimeout:           time.Millisecond * time.Duration(rdsConfig.PoolTimeoutMS),
+		DialTimeout:           time.Millisecond * time.Duration(rdsConfig.DialTimeoutMS),
+		ReadTimeout:           time.Millisecond * time.Duration(rdsConfig.ReadTimeoutMS),
+		WriteTimeout:          time.Millisecond * time.Duration(rdsConfig.WriteTimeoutMS),
+		ConnMaxIdleTime:       time.Second * time.Duration(rdsConfig.IdleTimeoutSec),
+		TLSConfig:             tlsConfig(rdsConfig),
+		ContextTimeoutEnabled: true,
+	})
+}
+
+// tlsConfig return tls config if tls is enabled
+func tlsConfig(rdsConfig *config.RedisConf) *tls.Config {
+	if !rdsConfig.TLS {
+		return nil
+	}
+	return &tls.Config{
+		InsecureSkipVerify: rdsConfig.TLSSkipVerify,
 	}
 }
 
 // GetConnection is for getting redis connection
-func GetConnection(connString string) (*redis.Client, error) {
+func GetConnection(connString string) (Redis, error) {
 	// if not init yet, do panic
 	mxRc.Lock()
 	defer mxRc.Unlock()
@@ -121,17 +241,45 @@ func GetConnection(connString string) (*redis.Client, error) {
 	return nil, errors.New("[GetConnection] Redis connection " + connString + " doesn't exist")
 }
 
-// PingRedis connection.
-func pingRedis() error {
-	// prevent data race
+// CloseRedis closes every redis connection pool, GetConnection fails after it is closed
+func CloseRedis() (err error) {
 	mxRc.Lock()
-	defer mxRc.Unlock()
-	for _, val := range RedisClients {
-		_, err := val.Conn.Ping().Result()
+	conns := RedisClients
+	RedisClients = make(map[string]*RedisConnInfo)
+	mxRc.Unlock()
+
+	for name, conn := range conns {
+		if conn == nil || conn.Conn == nil {
+			continue
+		}
+		if errClose := conn.Conn.Close(); errClose != nil {
+			err = errors.Wrapf(errClose, "[CloseRedis] fail to close %s", name)
+		}
+	}
+	return err
+}
+
+// PingRedis connection.
+func pingRedis(connString []string) error {
+	var failed []string
+	for _, name := range connString {
+		// prevent data race
+		mxRc.Lock()
+		val, ok := RedisClients[name]
+		mxRc.Unlock()
+		if !ok {
+			continue
+		}
+
+		_, err := val.Conn.Ping(context.Background()).Result()
 		if err != nil {
-			return err
+			failed = append(failed, name+": "+err.Error())
 		}
 	}
+
+	if len(failed) > 0 {
+		return errors.Errorf("[pingRedis] fail to ping redis %s", strings.Join(failed, ", "))
+	}
 	return nil
 }
 
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"net"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+type (
+	// TimeoutError is returned when redis command is not completed before deadline of its context
+	// or before client read/write timeout
+	TimeoutError struct {
+		Cmd string
+		Err error
+	}
+
+	// timeoutHook converts timeout error of every command and pipeline into TimeoutError
+	timeoutHook struct{}
+)
+
+func (e *TimeoutError) Error() string {
+	return "redis: " + e.Cmd + " timed out: " + e.Err.Error()
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout implements net.Error like timeout check
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// IsTimeout reports whether err (or any error it wraps) is a TimeoutError
+func IsTimeout(err error) bool {
+	var te *TimeoutError
+	return errors.As(err, &te)
+}
+
+// toTimeoutError wraps err into TimeoutError if it is caused by deadline or i/o timeout, other error is returned as is
+func toTimeoutError(cmd string, err error) error {
+	if err == nil || err == redis.Nil || IsTimeout(err) {
+		return err
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &TimeoutError{
+			Cmd: cmd,
+			Err: err,
+		}
+	}
+	return err
+}
+
+func (timeoutHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (timeoutHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := toTimeoutError(cmd.Name(), next(ctx, cmd))
+		if IsTimeout(err) {
+			cmd.SetErr(err)
+		}
+		return err
+	}
+}
+
+func (timeoutHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := toTimeoutError("pipeline", next(ctx, cmds))
+		for _, cmd := range cmds {
+			if cmdErr := toTimeoutError(cmd.Name(), cmd.Err()); IsTimeout(cmdErr) {
+				cmd.SetErr(cmdErr)
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// encodingVersion is the first byte of every encoded value, bump it when header format changes
+	encodingVersion byte = 1
+	// flagCompressed is set on the second byte when the payload is snappy compressed
+	flagCompressed byte = 0x80
+	// codecIDMask selects codec id from the second byte
+	codecIDMask byte = 0x7f
+	// headerSize is version byte and flag byte
+	headerSize = 2
+
+	// defaultCompressThreshold is payload size in bytes above which DefaultSerializer compresses the value
+	defaultCompressThreshold = 4096
+)
+
+// Codec id, custom codec should use id above 15
+const (
+	CodecJSON     byte = 1
+	CodecMsgpack  byte = 2
+	CodecProtobuf byte = 3
+	CodecGob      byte = 4
+)
+
+type (
+	// Codec marshal and unmarshal cache value
+	Codec interface {
+		// ID identifies the codec on encoded value, it must be unique and below 128
+		ID() byte
+		Marshal(v interface{}) ([]byte, error)
+		Unmarshal(data []byte, v interface{}) error
+	}
+
+	// Serializer encodes value with codec and prefix it with version and codec header,
+	// payload is snappy compressed when it is larger than CompressThreshold.
+	Serializer struct {
+		Codec Codec
+		// CompressThreshold is payload size in bytes above which the value is compressed, 0 disables compression
+		CompressThreshold int
+	}
+
+	jsonCodec     struct{}
+	msgpackCodec  struct{}
+	protobufCodec struct{}
+	gobCodec      struct{}
+)
+
+var (
+	// JSONCodec encodes value with encoding/json
+	JSONCodec Codec = jsonCodec{}
+	// MsgpackCodec encodes value with msgpack
+	MsgpackCodec Codec = msgpackCodec{}
+	// ProtobufCodec encodes value with protobuf, value must be proto.Message
+	ProtobufCodec Codec = protobufCodec{}
+	// GobCodec encodes value with encoding/gob
+	GobCodec Codec = gobCodec{}
+
+	// DefaultSerializer is used by SetValue and GetValue
+	DefaultSerializer = NewSerializer(JSONCodec, defaultCompressThreshold)
+
+	// ErrInvalidEncoding is returned when decoding value which is not encoded by Serializer
+	ErrInvalidEncoding = errors.New("cache: invalid encoded value")
+	// ErrUnknownCodec is returned when decoding value encoded by unregistered codec
+	ErrUnknownCodec = errors.New("cache: unknown codec")
+
+	codecs = map[byte]Codec{
+		CodecJSON:     JSONCodec,
+		CodecMsgpack:  MsgpackCodec,
+		CodecProtobuf: ProtobufCodec,
+		CodecGob:      GobCodec,
+	}
+	mxCodec sync.RWMutex
+)
+
+// RegisterCodec register custom codec so its value can be decoded
+func RegisterCodec(codec Codec) error {
+	if codec == nil || codec.ID() == 0 || codec.ID() > codecIDMask {
+		return errors.Wrap(ErrUnknownCodec, "[RegisterCodec] codec id must be between 1 and 127")
+	}
+
+	mxCodec.Lock()
+	defer mxCodec.Unlock()
+	if _, exist := codecs[codec.ID()]; exist {
+		return errors.Errorf("[RegisterCodec] codec id %d is already registered", codec.ID())
+	}
+	codecs[codec.ID()] = codec
+	return nil
+}
+
+func getCodec(id byte) (Codec, error) {
+	mxCodec.RLock()
+	defer mxCodec.RUnlock()
+	codec, ok := codecs[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownCodec, "codec id %d", id)
+	}
+	return codec, nil
+}
+
+// NewSerializer return serializer of given codec, compression is disabled if no threshold given
+func NewSerializer(codec Codec, compressThreshold ...int) *Serializer {
+	s := &Serializer{
+		Codec: codec,
+	}
+	if len(compressThreshold) > 0 {
+		s.CompressThreshold = compressThreshold[0]
+	}
+	return s
+}
+
+// Encode marshal value and prefix it with header
+func (s *Serializer) Encode(v interface{}) ([]byte, error) {
+	codec := s.Codec
+	if codec == nil {
+		codec = JSONCodec
+	}
+
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "[Serializer][Encode]")
+	}
+
+	flag := codec.ID() & codecIDMask
+	if s.CompressThreshold > 0 && len(payload) > s.CompressThreshold {
+		payload = snappy.Encode(nil, payload)
+		flag |= flagCompressed
+	}
+
+	data := make([]byte, 0, headerSize+len(payload))
+	data = append(data, encodingVersion, flag)
+	return append(data, payload...), nil
+}
+
+// Decode read header and unmarshal value with codec it was encoded with,
+// so value is still readable after serializer codec is changed
+func (s *Serializer) Decode(data []byte, v interface{}) error {
+	return Decode(data, v)
+}
+
+// Decode unmarshal value encoded by any Serializer
+func Decode(data []byte, v interface{}) error {
+	if len(data) < headerSize || data[0] != encodingVersion {
+		return errors.Wrap(ErrInvalidEncoding, "[Serializer][Decode]")
+	}
+
+	codec, err := getCodec(data[1] & codecIDMask)
+	if err != nil {
+		return errors.Wrap(err, "[Serializer][Decode]")
+	}
+
+	payload := data[headerSize:]
+	if data[1]&flagCompressed != 0 {
+		payload, err = snappy.Decode(nil, payload)
+		if err != nil {
+			return errors.Wrap(err, "[Serializer][Decode] fail to decompress")
+		}
+	}
+
+	err = codec.Unmarshal(payload, v)
+	if err != nil {
+		return errors.Wrap(err, "[Serializer][Decode]")
+	}
+	return nil
+}
+
+func (jsonCodec) ID() byte {
+	return CodecJSON
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (msgpackCodec) ID() byte {
+	return CodecMsgpack
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+func (protobufCodec) ID() byte {
+	return CodecProtobuf
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.Wrapf(ErrInvalidTypeResult, "[ProtobufCodec] %T is not proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return errors.Wrapf(ErrInvalidTypeResult, "[ProtobufCodec] %T is not proto.Message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+func (gobCodec) ID() byte {
+	return CodecGob
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultFetchJitter         = 0.1
+	defaultFetchRefreshTimeout = 10 * time.Second
+)
+
+type (
+	// FetchOptions is optional configuration of Fetch
+	FetchOptions struct {
+		// Conn is redis connection name. Default CacheGBT
+		Conn string
+		// Jitter is fraction of ttl randomly added or subtracted on write back to avoid keys expiring at once.
+		// Default 0.1, set negative value to disable
+		Jitter float64
+		// NegativeTTL is ttl of not found result returned by loader as ErrNotFound. 0 disables negative caching
+		NegativeTTL time.Duration
+		// StaleTTL is how long the value is kept after ttl passed, within this window stale value is served
+		// while it is refreshed in background (stale-while-revalidate). 0 disables serving stale value
+		StaleTTL time.Duration
+		// RefreshTimeout is timeout of loader ctx and write back, including background refresh of stale value.
+		// Load is detached from ctx of the caller, so cancelled caller does not fail other callers sharing it. Default 10s
+		RefreshTimeout time.Duration
+	}
+
+	// fetchEntry is the value stored in redis by Fetch
+	fetchEntry struct {
+		Value      json.RawMessage `json:"v,omitempty"`
+		NotFound   bool            `json:"nf,omitempty"`
+		FreshUntil int64           `json:"f"`
+	}
+)
+
+var (
+	// ErrNotFound is returned by Fetch loader to tell that the data does not exist, so it can be negatively cached
+	ErrNotFound = errors.New("cache: data is not found")
+
+	// fetchGroup collapses concurrent load of the same key
+	fetchGroup singleflight.Group
+)
+
+// Fetch read value of key from redis, on miss the loader is called and its result is written back with jittered ttl.
+// Concurrent misses of the same key only call loader once, each caller stops waiting for it when its ctx is done.
+// Loader should return ErrNotFound when the data does not exist, it is cached when NegativeTTL is set.
+// Loader ctx is not ctx of the caller, it has RefreshTimeout deadline.
+func Fetch[T any](ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error), opt ...FetchOptions) (res T, err error) {
+	if key == "" || ttl <= 0 || loader == nil {
+		return res, errors.Wrap(ErrInvalidKeyorField, "[Fetch]")
+	}
+
+	o := fetchOptions(opt)
+	c := NewCache(o.Conn)
+	// callers of the same key with different type do not share a load
+	flight := fmt.Sprintf("%s:%T:%s", o.Conn, res, key)
+
+	raw, err := c.Get(ctx, key)
+	switch {
+	case err == nil:
+		entry := fetchEntry{}
+		if errDecode := json.Unmarshal([]byte(raw), &entry); errDecode != nil {
+			log.Printf("[Fetch] fail to decode cached value of %s, err: %v", key, errDecode)
+			break
+		}
+
+		if time.Now().UnixNano()/int64(time.Millisecond) >= entry.FreshUntil {
+			// stale value is served while it is refreshed in background
+			fetchGroup.DoChan(flight, func() (interface{}, error) {
+				return load(c, key, ttl, loader, o)
+			})
+		}
+
+		return decodeEntry[T](entry)
+	case errors.Cause(err) != ErrCacheMiss:
+		log.Printf("[Fetch] fail to get %s from redis, fallback to loader, err: %v", key, err)
+	}
+
+	ch := fetchGroup.DoChan(flight, func() (interface{}, error) {
+		return load(c, key, ttl, loader, o)
+	})
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return res, r.Err
+		}
+		// nil value of interface type T is not T
+		val, ok := r.Val.(T)
+		if !ok && r.Val != nil {
+			return res, errors.Errorf("[Fetch] loaded value of %s is %T, not %T", key, r.Val, res)
+		}
+		return val, nil
+	case <-ctx.Done():
+		return res, errors.Wrapf(ctx.Err(), "[Fetch] %s", key)
+	}
+}
+
+// load call loader and write its result back to redis, it is shared by concurrent callers
+// so it runs on its own context instead of ctx of the first caller
+func load[T any](c ICache, key string, ttl time.Duration, loader func(ctx context.Context) (T, error), o FetchOptions) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), o.RefreshTimeout)
+	defer cancel()
+
+	res, err := loader(ctx)
+	if errors.Is(err, ErrNotFound) {
+		if o.NegativeTTL > 0 {
+			writeEntry(ctx, c, key, fetchEntry{NotFound: true}, o.NegativeTTL, o)
+		}
+		return res, err
+	}
+	if err != nil {
+		return res, err
+	}
+
+	value, err := json.Marshal(res)
+	if err != nil {
+		return res, errors.Wrapf(err, "[Fetch] fail to encode value of %s", key)
+	}
+	writeEntry(ctx, c, key, fetchEntry{Value: value}, ttl, o)
+
+	return res, nil
+}
+
+// writeEntry stores the entry with jittered ttl, failure is only logged since the value is already loaded
+func writeEntry(ctx context.Context, c ICache, key string, entry fetchEntry, ttl time.Duration, o FetchOptions) {
+	ttl = jitter(ttl, o.Jitter)
+	entry.FreshUntil = time.Now().Add(ttl).UnixNano() / int64(time.Millisecond)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("[Fetch] fail to encode cache entry of %s, err: %v", key, err)
+		return
+	}
+
+	err = c.Set(ctx, key, string(data), ttl+o.StaleTTL)
+	if err != nil {
+		log.Printf("[Fetch] fail to write %s to redis, err: %v", key, err)
+	}
+}
+
+// decodeEntry return cached value, ErrNotFound is returned for negatively cached entry
+func decodeEntry[T any](entry fetchEntry) (res T, err error) {
+	if entry.NotFound {
+		return res, ErrNotFound
+	}
+
+	err = json.Unmarshal(entry.Value, &res)
+	if err != nil {
+		return res, errors.Wrap(err, "[Fetch] fail to decode cached value")
+	}
+	return res, nil
+}
+
+// jitter randomly adds or subtracts fraction of ttl
+func jitter(ttl time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return ttl
+	}
+
+	delta := time.Duration((rand.Float64()*2 - 1) * fraction * float64(ttl))
+	if ttl+delta <= 0 {
+		return ttl
+	}
+	return ttl + delta
+}
+
+// fetchOptions fill default value of fetch options
+func fetchOptions(opt []FetchOptions) (o FetchOptions) {
+	if len(opt) > 0 {
+		o = opt[0]
+	}
+	if o.Conn == "" {
+		o.Conn = CacheGBT
+	}
+	if o.Jitter == 0 {
+		o.Jitter = defaultFetchJitter
+	}
+	if o.RefreshTimeout <= 0 {
+		o.RefreshTimeout = defaultFetchRefreshTimeout
+	}
+	return
+}
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// hashTag is struct tag used to map struct field into redis hash field, e.g. `cache:"topic"`.
+	// Field without the tag or with "-" is skipped, add ",omitempty" to skip zero value on write.
+	hashTag = "cache"
+)
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+type hashField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// StructToHash convert struct (or pointer to struct) into redis hash using cache tag.
+// Scalar field is formatted with strconv, time.Time with RFC3339Nano and other type is encoded as JSON.
+func StructToHash(v interface{}) (map[string]string, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.Wrapf(ErrInvalidTypeResult, "[StructToHash] %T is not a struct", v)
+	}
+
+	result := make(map[string]string)
+	for _, f := range hashFields(rv.Type()) {
+		fv := rv.Field(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+
+		value, err := formatHashValue(fv)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[StructToHash] field %s", f.name)
+		}
+		result[f.name] = value
+	}
+
+	return result, nil
+}
+
+// HashToStruct fill struct pointed by v from redis hash using cache tag, missing field is left untouched
+func HashToStruct(data map[string]string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.Wrapf(ErrInvalidTypeResult, "[HashToStruct] %T is not a pointer to struct", v)
+	}
+	rv = rv.Elem()
+
+	for _, f := range hashFields(rv.Type()) {
+		value, ok := data[f.name]
+		if !ok {
+			continue
+		}
+
+		err := parseHashValue(value, rv.Field(f.index))
+		if err != nil {
+			return errors.Wrapf(err, "[HashToStruct] field %s", f.name)
+		}
+	}
+
+	return nil
+}
+
+// hashFields return exported fields which have cache tag
+func hashFields(rt reflect.Type) []hashField {
+	fields := make([]hashField, 0, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		tag, ok := sf.Tag.Lookup(hashTag)
+		if !ok || tag == "-" {
+			continue
+		}
+
+		opts := strings.Split(tag, ",")
+		f := hashField{
+			name:  opts[0],
+			index: i,
+		}
+		if f.name == "" {
+			f.name = sf.Name
+		}
+		for _, opt := range opts[1:] {
+			if opt == "omitempty" {
+				f.omitEmpty = true
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func formatHashValue(fv reflect.Value) (string, error) {
+	if fv.Type() == timeType {
+		return fv.Interface().(time.Time).Format(time.RFC3339Nano), nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, 64), nil
+	}
+
+	data, err := json.Marshal(fv.Interface())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func parseHashValue(value string, fv reflect.Value) error {
+	if fv.Type() == timeType {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	default:
+		return json.Unmarshal([]byte(value), fv.Addr().Interface())
+	}
+	return nil
+}
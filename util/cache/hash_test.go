@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStructToHashFloat(t *testing.T) {
+	type item struct {
+		Price32 float32 `cache:"price32"`
+		Price64 float64 `cache:"price64"`
+	}
+
+	got, err := StructToHash(item{Price32: 0.1, Price64: 0.1})
+	if err != nil {
+		t.Fatalf("StructToHash err = %v", err)
+	}
+
+	// float32 is formatted with its own precision, not as widened float64 (0.10000000149011612)
+	want := map[string]string{"price32": "0.1", "price64": "0.1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StructToHash = %v, want %v", got, want)
+	}
+
+	var res item
+	if err := HashToStruct(got, &res); err != nil {
+		t.Fatalf("HashToStruct err = %v", err)
+	}
+	if res.Price32 != 0.1 || res.Price64 != 0.1 {
+		t.Errorf("HashToStruct = %+v, want round trip of 0.1", res)
+	}
+}
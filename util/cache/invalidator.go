@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bitly/go-nsq"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	redisclient "github.com/golang-base-template/util/cache/client"
+	"github.com/golang-base-template/util/config"
+	nsqpublisher "github.com/golang-base-template/util/nsq"
+)
+
+const (
+	// InvalidationRedis broadcast invalidation using redis pub/sub
+	InvalidationRedis = "redis"
+	// InvalidationNSQ broadcast invalidation using nsq topic
+	InvalidationNSQ = "nsq"
+
+	// receive error is retried after backoff starting from minReceiveBackoff doubled up to maxReceiveBackoff
+	minReceiveBackoff = 100 * time.Millisecond
+	maxReceiveBackoff = 5 * time.Second
+)
+
+type (
+	// IInvalidator broadcasts invalidation payload to every pod
+	IInvalidator interface {
+		Publish(ctx context.Context, payload []byte) error
+		// Subscribe calls fn for every payload published by any pod, including the publisher itself.
+		// reset is called when the subscription is recovered after payloads may have been missed.
+		Subscribe(fn func(payload []byte), reset func()) error
+		Close() error
+	}
+
+	redisInvalidator struct {
+		conn    string
+		channel string
+
+		mx     sync.Mutex
+		pubsub *redis.PubSub
+		closed bool
+	}
+
+	nsqInvalidator struct {
+		conn     string
+		topic    string
+		consumer *nsq.Consumer
+	}
+)
+
+// NewRedisInvalidator return invalidator using redis pub/sub channel
+func NewRedisInvalidator(conn, channel string) IInvalidator {
+	return &redisInvalidator{
+		conn:    conn,
+		channel: channel,
+	}
+}
+
+func (ri *redisInvalidator) Publish(ctx context.Context, payload []byte) error {
+	rds, err := redisclient.GetConnection(ri.conn)
+	if err != nil {
+		return errors.Wrap(ErrConn, "[RedisInvalidator][Publish]")
+	}
+	return rds.Publish(ctx, ri.channel, string(payload)).Err()
+}
+
+func (ri *redisInvalidator) Subscribe(fn func(payload []byte), reset func()) error {
+	rds, err := redisclient.GetConnection(ri.conn)
+	if err != nil {
+		return errors.Wrap(ErrConn, "[RedisInvalidator][Subscribe]")
+	}
+
+	// wait for subscription confirmation so invalidation published right after this call is not missed
+	pubsub := rds.Subscribe(context.Background(), ri.channel)
+	_, err = pubsub.Receive(context.Background())
+	if err != nil {
+		pubsub.Close()
+		return errors.Wrapf(err, "[RedisInvalidator][Subscribe] fail to subscribe %s", ri.channel)
+	}
+
+	ri.mx.Lock()
+	ri.pubsub = pubsub
+	ri.mx.Unlock()
+
+	go func() {
+		var backoff time.Duration
+		for {
+			msg, err := pubsub.ReceiveMessage(context.Background())
+			if err != nil {
+				ri.mx.Lock()
+				closed := ri.closed
+				ri.mx.Unlock()
+				if closed {
+					return
+				}
+
+				// pubsub reconnects on next receive, wait so it does not spin while redis is down
+				if backoff < minReceiveBackoff {
+					backoff = minReceiveBackoff
+				} else if backoff *= 2; backoff > maxReceiveBackoff {
+					backoff = maxReceiveBackoff
+				}
+				log.Printf("[RedisInvalidator][Subscribe] fail to receive message from %s, retry in %v, err: %v", ri.channel, backoff, err)
+				time.Sleep(backoff)
+				continue
+			}
+
+			if backoff > 0 {
+				// payload published while the subscription was down is lost
+				log.Printf("[RedisInvalidator][Subscribe] subscription of %s is recovered", ri.channel)
+				backoff = 0
+				reset()
+			}
+			fn([]byte(msg.Payload))
+		}
+	}()
+
+	return nil
+}
+
+func (ri *redisInvalidator) Close() error {
+	ri.mx.Lock()
+	defer ri.mx.Unlock()
+
+	ri.closed = true
+	if ri.pubsub == nil {
+		return nil
+	}
+	return ri.pubsub.Close()
+}
+
+// NewNSQInvalidator return invalidator using nsq topic, conn is publisher connection in util/nsq.
+// Each pod consumes the topic with its own ephemeral channel so every pod receives every payload.
+func NewNSQInvalidator(conn, topic string) IInvalidator {
+	return &nsqInvalidator{
+		conn:  conn,
+		topic: topic,
+	}
+}
+
+func (ni *nsqInvalidator) Publish(ctx context.Context, payload []byte) error {
+	return nsqpublisher.SendNSQTo(ni.topic, payload, ni.conn)
+}
+
+// Subscribe never calls reset since nsq consumer reconnects without notice, payload published while the pod
+// is disconnected is missed until L1 entry expires (see TieredCache local-ttl-sec)
+func (ni *nsqInvalidator) Subscribe(fn func(payload []byte), reset func()) error {
+	consumer, err := nsq.NewConsumer(ni.topic, "cache-"+instanceID+"#ephemeral", nsq.NewConfig())
+	if err != nil {
+		return errors.Wrapf(err, "[NSQInvalidator][Subscribe] fail to create consumer of %s", ni.topic)
+	}
+
+	consumer.AddHandler(nsq.HandlerFunc(func(message *nsq.Message) error {
+		fn(message.Body)
+		return nil
+	}))
+
+	err = consumer.ConnectToNSQLookupds(config.Get().Consumer.LookupdAddress)
+	if err != nil {
+		return errors.Wrapf(err, "[NSQInvalidator][Subscribe] fail to connect to lookupd for %s", ni.topic)
+	}
+
+	ni.consumer = consumer
+	return nil
+}
+
+func (ni *nsqInvalidator) Close() error {
+	if ni.consumer == nil {
+		return nil
+	}
+	ni.consumer.Stop()
+	<-ni.consumer.StopChan
+	return nil
+}
+
+// instanceID identifies this process on invalidation broadcast
+var instanceID = func() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Println("[Cache] fail to generate instance id:", err)
+	}
+	return hex.EncodeToString(b)
+}()
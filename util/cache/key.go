@@ -0,0 +1,280 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	redisclient "github.com/golang-base-template/util/cache/client"
+)
+
+const (
+	// keySeparator separates key segments, e.g. "gbt:employee:{id}"
+	keySeparator = ":"
+	// scanCount is number of keys hinted per SCAN iteration on pattern invalidation
+	scanCount = 1000
+)
+
+type (
+	// KeyTemplate is a registered cache key schema, e.g. "gbt:employee:{id}" with its default TTL and codec.
+	// Param is written as {name} and filled in order by Build.
+	KeyTemplate struct {
+		Template string
+		TTL      time.Duration
+		Codec    Codec
+
+		params   []string
+		segments []string
+	}
+
+	// Key is a cache key built from a registered template
+	Key struct {
+		key      string
+		template *KeyTemplate
+	}
+)
+
+var (
+	// KeyServicePrefix is the first segment every registered key template must start with
+	KeyServicePrefix = "gbt"
+
+	// ErrInvalidKeyTemplate is returned when key template is malformed or does not start with KeyServicePrefix
+	ErrInvalidKeyTemplate = errors.New("cache: invalid key template")
+	// ErrKeyCollision is returned when key template can produce the same key as a registered template
+	ErrKeyCollision = errors.New("cache: key template collides with registered template")
+	// ErrInvalidKeyParam is returned when number of key params does not match the template,
+	// or a param is empty or contains key separator
+	ErrInvalidKeyParam = errors.New("cache: invalid key param")
+
+	keyParam = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+	keyTemplates = map[string]*KeyTemplate{}
+	mxKey        sync.RWMutex
+)
+
+// RegisterKey registers key template of a module, the template must start with KeyServicePrefix
+// and must not produce the same key as other registered template. Codec defaults to DefaultSerializer codec.
+func RegisterKey(template string, ttl time.Duration, codec Codec) (*KeyTemplate, error) {
+	if !strings.HasPrefix(template, KeyServicePrefix+keySeparator) {
+		return nil, errors.Wrapf(ErrInvalidKeyTemplate, "[RegisterKey] %s must start with %s%s", template, KeyServicePrefix, keySeparator)
+	}
+	if strings.Count(template, "{") != len(keyParam.FindAllString(template, -1)) || strings.Contains(template, "*") {
+		return nil, errors.Wrapf(ErrInvalidKeyTemplate, "[RegisterKey] %s has malformed param", template)
+	}
+	if codec == nil {
+		codec = DefaultSerializer.Codec
+	}
+
+	kt := &KeyTemplate{
+		Template: template,
+		TTL:      ttl,
+		Codec:    codec,
+		segments: strings.Split(template, keySeparator),
+	}
+	for _, m := range keyParam.FindAllStringSubmatch(template, -1) {
+		kt.params = append(kt.params, m[1])
+	}
+
+	mxKey.Lock()
+	defer mxKey.Unlock()
+	for _, registered := range keyTemplates {
+		if registered.collides(kt) {
+			return nil, errors.Wrapf(ErrKeyCollision, "[RegisterKey] %s and %s", template, registered.Template)
+		}
+	}
+	keyTemplates[template] = kt
+
+	return kt, nil
+}
+
+// MustRegisterKey is RegisterKey which panics on error, use it on package var so collision fails at startup
+func MustRegisterKey(template string, ttl time.Duration, codec Codec) *KeyTemplate {
+	kt, err := RegisterKey(template, ttl, codec)
+	if err != nil {
+		panic(err)
+	}
+	return kt
+}
+
+// RegisteredKeys return every registered key template sorted by template
+func RegisteredKeys() []*KeyTemplate {
+	mxKey.RLock()
+	defer mxKey.RUnlock()
+
+	res := make([]*KeyTemplate, 0, len(keyTemplates))
+	for _, kt := range keyTemplates {
+		res = append(res, kt)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Template < res[j].Template
+	})
+	return res
+}
+
+// GetKeyTemplate return registered key template
+func GetKeyTemplate(template string) (*KeyTemplate, bool) {
+	mxKey.RLock()
+	defer mxKey.RUnlock()
+
+	kt, ok := keyTemplates[template]
+	return kt, ok
+}
+
+// Build fills template params in order, e.g. Build(123) of "gbt:employee:{id}" is "gbt:employee:123"
+func (kt *KeyTemplate) Build(params ...interface{}) (Key, error) {
+	if len(params) != len(kt.params) {
+		return Key{}, errors.Wrapf(ErrInvalidKeyParam, "[Build] %s needs %d params, got %d", kt.Template, len(kt.params), len(params))
+	}
+
+	values := make([]string, len(params))
+	for i, param := range params {
+		v := fmt.Sprint(param)
+		if v == "" {
+			return Key{}, errors.Wrapf(ErrInvalidKeyParam, "[Build] %s has empty param %s", kt.Template, kt.params[i])
+		}
+		// separator in param would produce key of other template
+		if strings.Contains(v, keySeparator) {
+			return Key{}, errors.Wrapf(ErrInvalidKeyParam, "[Build] param %s of %s contains %q", kt.params[i], kt.Template, keySeparator)
+		}
+		values[i] = v
+	}
+
+	i := 0
+	key := keyParam.ReplaceAllStringFunc(kt.Template, func(string) string {
+		v := values[i]
+		i++
+		return v
+	})
+
+	return Key{key: key, template: kt}, nil
+}
+
+// Pattern return SCAN pattern matching every key of the template, e.g. "gbt:employee:*".
+// Glob "*" also matches separator, so the pattern may match keys of longer template sharing the same prefix.
+func (kt *KeyTemplate) Pattern() string {
+	return keyParam.ReplaceAllString(kt.Template, "*")
+}
+
+// Params return param names of the template in order
+func (kt *KeyTemplate) Params() []string {
+	return append([]string(nil), kt.params...)
+}
+
+// collides reports whether both templates can produce the same key,
+// segment containing param is assumed to match any value
+func (kt *KeyTemplate) collides(other *KeyTemplate) bool {
+	if len(kt.segments) != len(other.segments) {
+		return false
+	}
+
+	for i := range kt.segments {
+		a, b := kt.segments[i], other.segments[i]
+		if a != b && !keyParam.MatchString(a) && !keyParam.MatchString(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// String return the key
+func (k Key) String() string {
+	return k.key
+}
+
+// TTL return default TTL of the key template
+func (k Key) TTL() time.Duration {
+	return k.template.TTL
+}
+
+// Template return template of the key
+func (k Key) Template() *KeyTemplate {
+	return k.template
+}
+
+// Encode encodes value with codec of the key template, the value can be read back with Decode or GetValue
+func (k Key) Encode(value interface{}) ([]byte, error) {
+	return NewSerializer(k.template.Codec, DefaultSerializer.CompressThreshold).Encode(value)
+}
+
+// InvalidatePattern unlinks every key matching the pattern using SCAN, every master node is scanned on cluster mode.
+// Pattern must start with KeyServicePrefix so keys of other service sharing the redis are never touched.
+func InvalidatePattern(ctx context.Context, pattern string, conn ...string) (res int64, err error) {
+	if !strings.HasPrefix(pattern, KeyServicePrefix+keySeparator) {
+		return 0, errors.Wrapf(ErrInvalidKeyTemplate, "[InvalidatePattern] %s must start with %s%s", pattern, KeyServicePrefix, keySeparator)
+	}
+
+	connName := CacheGBT
+	if len(conn) > 0 {
+		connName = conn[0]
+	}
+	rds, err := redisclient.GetConnection(connName)
+	if err != nil {
+		return 0, errors.Wrap(ErrConn, "[InvalidatePattern]")
+	}
+
+	var (
+		mx    sync.Mutex
+		total int64
+	)
+	invalidate := func(ctx context.Context, node redis.UniversalClient) error {
+		n, err := unlinkPattern(ctx, node, pattern)
+		mx.Lock()
+		total += n
+		mx.Unlock()
+		return err
+	}
+
+	if cluster, ok := rds.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return invalidate(ctx, node)
+		})
+	} else {
+		err = invalidate(ctx, rds)
+	}
+	if err != nil {
+		return total, errors.Wrapf(err, "[InvalidatePattern] %s", pattern)
+	}
+	return total, nil
+}
+
+// unlinkPattern scans a single node and unlinks matching keys per scan page
+func unlinkPattern(ctx context.Context, rds redis.UniversalClient, pattern string) (int64, error) {
+	var (
+		cursor uint64
+		total  int64
+	)
+	for {
+		keys, next, err := rds.Scan(ctx, cursor, pattern, scanCount).Result()
+		if err != nil {
+			return total, err
+		}
+
+		if len(keys) > 0 {
+			// keys of a page may belong to different cluster slots, so each key is unlinked by its own command
+			cmds, err := rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, key := range keys {
+					pipe.Unlink(ctx, key)
+				}
+				return nil
+			})
+			for _, cmd := range cmds {
+				total += cmd.(*redis.IntCmd).Val()
+			}
+			if err != nil {
+				return total, err
+			}
+		}
+
+		if next == 0 {
+			return total, nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,389 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/golang-base-template/util/cache"
+	redisclient "github.com/golang-base-template/util/cache/client"
+)
+
+const (
+	defaultRetryBackoff    = 50 * time.Millisecond
+	defaultMaxRetryBackoff = 2 * time.Second
+	// clockDriftFactor is percentage of lock ttl considered as clock drift between redis instances
+	clockDriftFactor = 0.01
+)
+
+type (
+	// ILocker obtains distributed lock from one or more redis connections.
+	// When more than one connection is given, lock is obtained only if majority of the instances agree (Redlock).
+	ILocker interface {
+		Obtain(ctx context.Context, key string, ttl time.Duration, opt ...Options) (ILock, error)
+	}
+
+	// ILock is an obtained distributed lock
+	ILock interface {
+		// Key return locked key
+		Key() string
+		// Token return random value that identifies this lock holder
+		Token() string
+		// TTL return remaining time to live of the lock, ErrNotHeld is returned if lock is not held anymore
+		TTL(ctx context.Context) (time.Duration, error)
+		// Refresh extends the lock with new ttl, ErrNotHeld is returned if lock is not held anymore
+		Refresh(ctx context.Context, ttl time.Duration) error
+		// Release releases the lock and stops auto refresh, ErrNotHeld is returned if lock is not held anymore
+		Release(ctx context.Context) error
+		// Lost is closed when auto refresh fails to extend the lock
+		Lost() <-chan struct{}
+	}
+
+	// Options is optional configuration of Obtain
+	Options struct {
+		// RetryCount is how many times obtain is retried when lock is held by others, 0 means no retry.
+		// Retry stops earlier if ctx is done.
+		RetryCount int
+		// RetryBackoff is base delay between retries, it is doubled on each retry. Default 50ms
+		RetryBackoff time.Duration
+		// MaxRetryBackoff is the maximum delay between retries. Default 2s
+		MaxRetryBackoff time.Duration
+		// AutoRefresh extends the lock periodically until it is released
+		AutoRefresh bool
+		// RefreshInterval is interval of auto refresh, it must be less than lock ttl. Default a third of lock ttl,
+		// which is also used when the interval is not less than ttl so the lock does not expire while it is held
+		RefreshInterval time.Duration
+	}
+
+	locker struct {
+		conns  []string
+		quorum int
+	}
+
+	lock struct {
+		locker *locker
+		key    string
+		token  string
+
+		mx  sync.Mutex
+		ttl time.Duration
+
+		stopOnce sync.Once
+		stop     chan struct{}
+		lostOnce sync.Once
+		lost     chan struct{}
+	}
+)
+
+var (
+	// ErrNotObtained is returned by Obtain when lock is held by others
+	ErrNotObtained = errors.New("lock: not obtained")
+	// ErrNotHeld is returned when the lock is expired or taken over by others
+	ErrNotHeld = errors.New("lock: not held")
+	// ErrInvalidKey is returned when lock key or ttl is not specified
+	ErrInvalidKey = errors.New("lock: no key or ttl is specified")
+
+	// releaseScript deletes the key only if it still holds our token
+	releaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+	// refreshScript extends the key only if it still holds our token
+	refreshScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+
+	// pttlScript return remaining ttl of the key only if it still holds our token
+	pttlScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pttl", KEYS[1])
+end
+return -3`)
+)
+
+// NewLocker return locker for given redis connections (see RedisClients).
+// CacheGBT connection is used if no connection given.
+func NewLocker(conn ...string) ILocker {
+	if len(conn) == 0 {
+		conn = []string{cache.CacheGBT}
+	}
+
+	return &locker{
+		conns:  conn,
+		quorum: len(conn)/2 + 1,
+	}
+}
+
+// Obtain tries to obtain lock of the key using SET NX PX with random token.
+// ErrNotObtained is returned if the lock is still held by others after all retries.
+func (l *locker) Obtain(ctx context.Context, key string, ttl time.Duration, opt ...Options) (ILock, error) {
+	if key == "" || ttl <= 0 {
+		return nil, errors.Wrap(ErrInvalidKey, "[Lock][Obtain]")
+	}
+
+	var o Options
+	if len(opt) > 0 {
+		o = opt[0]
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = defaultRetryBackoff
+	}
+	if o.MaxRetryBackoff <= 0 {
+		o.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, errors.Wrap(err, "[Lock][Obtain] fail to generate token")
+	}
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		acquired := l.acquire(ctx, key, token, ttl)
+
+		// lock is only valid if it is acquired on majority of instances before it expires
+		drift := time.Duration(float64(ttl)*clockDriftFactor) + 2*time.Millisecond
+		validity := ttl - time.Since(start) - drift
+		if acquired >= l.quorum && validity > 0 {
+			lk := &lock{
+				locker: l,
+				key:    key,
+				token:  token,
+				ttl:    ttl,
+				stop:   make(chan struct{}),
+				lost:   make(chan struct{}),
+			}
+			if o.AutoRefresh {
+				go lk.autoRefresh(o.RefreshInterval)
+			}
+			return lk, nil
+		}
+
+		// release partially acquired instances so others can obtain the lock,
+		// ctx may already be done at this point so it is not used for the release
+		l.release(context.Background(), key, token)
+
+		if attempt >= o.RetryCount {
+			return nil, errors.Wrapf(ErrNotObtained, "[Lock][Obtain] key: %s", key)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "[Lock][Obtain] key: %s", key)
+		case <-time.After(backoff(attempt, o.RetryBackoff, o.MaxRetryBackoff)):
+		}
+	}
+}
+
+// acquire set the key on every instance and return number of instances acquired
+func (l *locker) acquire(ctx context.Context, key, token string, ttl time.Duration) (acquired int) {
+	for _, conn := range l.conns {
+		if ctx.Err() != nil {
+			return
+		}
+
+		rds, err := redisclient.GetConnection(conn)
+		if err != nil {
+			continue
+		}
+
+		ok, err := rds.SetNX(ctx, key, token, ttl).Result()
+		if err != nil {
+			log.Printf("[Lock][acquire] fail to set key %s on %s, err: %v", key, conn, err)
+			continue
+		}
+		if ok {
+			acquired++
+		}
+	}
+	return
+}
+
+// release deletes the key on every instance that still holds the token and return number of instances released
+func (l *locker) release(ctx context.Context, key, token string) (released int) {
+	for _, conn := range l.conns {
+		rds, err := redisclient.GetConnection(conn)
+		if err != nil {
+			continue
+		}
+
+		res, err := releaseScript.Run(ctx, rds, []string{key}, token).Result()
+		if err != nil {
+			log.Printf("[Lock][release] fail to release key %s on %s, err: %v", key, conn, err)
+			continue
+		}
+		if toInt64(res) == 1 {
+			released++
+		}
+	}
+	return
+}
+
+func (lk *lock) Key() string {
+	return lk.key
+}
+
+func (lk *lock) Token() string {
+	return lk.token
+}
+
+func (lk *lock) Lost() <-chan struct{} {
+	return lk.lost
+}
+
+// TTL return the smallest remaining ttl across instances that still hold the lock
+func (lk *lock) TTL(ctx context.Context) (time.Duration, error) {
+	var (
+		held int
+		ttl  = time.Duration(math.MaxInt64)
+	)
+
+	for _, conn := range lk.locker.conns {
+		rds, err := redisclient.GetConnection(conn)
+		if err != nil {
+			continue
+		}
+
+		res, err := pttlScript.Run(ctx, rds, []string{lk.key}, lk.token).Result()
+		if err != nil {
+			continue
+		}
+
+		pttl := toInt64(res)
+		if pttl <= 0 {
+			continue
+		}
+
+		held++
+		if d := time.Duration(pttl) * time.Millisecond; d < ttl {
+			ttl = d
+		}
+	}
+
+	if held < lk.locker.quorum {
+		return 0, errors.Wrapf(ErrNotHeld, "[Lock][TTL] key: %s", lk.key)
+	}
+	return ttl, nil
+}
+
+// Refresh extends the lock on every instance that still holds the token
+func (lk *lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.Wrap(ErrInvalidKey, "[Lock][Refresh]")
+	}
+
+	var refreshed int
+	for _, conn := range lk.locker.conns {
+		if ctx.Err() != nil {
+			return errors.Wrapf(ctx.Err(), "[Lock][Refresh] key: %s", lk.key)
+		}
+
+		rds, err := redisclient.GetConnection(conn)
+		if err != nil {
+			continue
+		}
+
+		res, err := refreshScript.Run(ctx, rds, []string{lk.key}, lk.token, ttl.Nanoseconds()/int64(time.Millisecond)).Result()
+		if err != nil {
+			log.Printf("[Lock][Refresh] fail to refresh key %s on %s, err: %v", lk.key, conn, err)
+			continue
+		}
+		if toInt64(res) == 1 {
+			refreshed++
+		}
+	}
+
+	if refreshed < lk.locker.quorum {
+		return errors.Wrapf(ErrNotHeld, "[Lock][Refresh] key: %s", lk.key)
+	}
+
+	lk.mx.Lock()
+	lk.ttl = ttl
+	lk.mx.Unlock()
+	return nil
+}
+
+// Release releases the lock on every instance and stops auto refresh
+func (lk *lock) Release(ctx context.Context) error {
+	lk.stopOnce.Do(func() {
+		close(lk.stop)
+	})
+
+	if lk.locker.release(ctx, lk.key, lk.token) == 0 {
+		return errors.Wrapf(ErrNotHeld, "[Lock][Release] key: %s", lk.key)
+	}
+	return nil
+}
+
+// autoRefresh extends the lock periodically until released, Lost is closed when refresh fails.
+// Interval is checked against current ttl on every refresh since Refresh may shorten it.
+func (lk *lock) autoRefresh(interval time.Duration) {
+	for {
+		lk.mx.Lock()
+		ttl := lk.ttl
+		lk.mx.Unlock()
+
+		next := interval
+		if next <= 0 || next >= ttl {
+			next = ttl / 3
+		}
+
+		timer := time.NewTimer(next)
+		select {
+		case <-lk.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			ctx, cancel := context.WithTimeout(context.Background(), next)
+			err := lk.Refresh(ctx, ttl)
+			cancel()
+			if err != nil {
+				log.Printf("[Lock][autoRefresh] lock is lost, err: %v", err)
+				lk.lostOnce.Do(func() {
+					close(lk.lost)
+				})
+				return
+			}
+		}
+	}
+}
+
+// backoff return exponential delay for given attempt capped by max
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	if attempt > 30 {
+		return max
+	}
+	d := base << uint(attempt)
+	if d <= 0 || d > max {
+		return max
+	}
+	return d
+}
+
+// randomToken return random value to identify the lock holder
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// toInt64 converts lua script integer reply
+func toInt64(v interface{}) int64 {
+	if n, ok := v.(int64); ok {
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,250 @@
+package lock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pkg/errors"
+
+	"github.com/golang-base-template/util/cache"
+	redisclient "github.com/golang-base-template/util/cache/client"
+)
+
+// newTestRedis points given connections to their own fresh miniredis, CacheGBT is used if no connection given
+func newTestRedis(t *testing.T, conns ...string) []*miniredis.Miniredis {
+	if len(conns) == 0 {
+		conns = []string{cache.CacheGBT}
+	}
+
+	mrs := make([]*miniredis.Miniredis, 0, len(conns))
+	redisclient.RedisClients = redisclient.RedisConnsMap{}
+	for _, conn := range conns {
+		mr := miniredis.RunT(t)
+		redisclient.RedisClients[conn] = redisclient.NewConnection(conn, mr.Addr(), "")
+		mrs = append(mrs, mr)
+	}
+	return mrs
+}
+
+func TestObtain(t *testing.T) {
+	ctx := context.Background()
+	mr := newTestRedis(t)[0]
+	locker := NewLocker()
+
+	lk, err := locker.Obtain(ctx, "gbt:lock", time.Minute)
+	if err != nil {
+		t.Fatalf("Obtain err = %v", err)
+	}
+	mr.CheckGet(t, "gbt:lock", lk.Token())
+	if ttl := mr.TTL("gbt:lock"); ttl != time.Minute {
+		t.Errorf("ttl = %v, want %v", ttl, time.Minute)
+	}
+
+	if _, err := locker.Obtain(ctx, "gbt:lock", time.Minute); !errors.Is(err, ErrNotObtained) {
+		t.Errorf("Obtain held lock err = %v, want %v", err, ErrNotObtained)
+	}
+	if _, err := locker.Obtain(ctx, "", time.Minute); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Obtain empty key err = %v, want %v", err, ErrInvalidKey)
+	}
+
+	if err := lk.Release(ctx); err != nil {
+		t.Fatalf("Release err = %v", err)
+	}
+	if mr.Exists("gbt:lock") {
+		t.Error("gbt:lock is not released")
+	}
+	if _, err := locker.Obtain(ctx, "gbt:lock", time.Minute); err != nil {
+		t.Errorf("Obtain released lock err = %v", err)
+	}
+}
+
+func TestObtainRetry(t *testing.T) {
+	ctx := context.Background()
+	mr := newTestRedis(t)[0]
+	mr.Set("gbt:lock", "other")
+	mr.SetTTL("gbt:lock", time.Minute)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		mr.Del("gbt:lock")
+	}()
+
+	lk, err := NewLocker().Obtain(ctx, "gbt:lock", time.Minute, Options{
+		RetryCount:   20,
+		RetryBackoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Obtain err = %v", err)
+	}
+	mr.CheckGet(t, "gbt:lock", lk.Token())
+}
+
+func TestReleaseByOwnerOnly(t *testing.T) {
+	ctx := context.Background()
+	mr := newTestRedis(t)[0]
+
+	lk, err := NewLocker().Obtain(ctx, "gbt:lock", time.Minute)
+	if err != nil {
+		t.Fatalf("Obtain err = %v", err)
+	}
+
+	// the lock expired and is taken over by other holder
+	mr.Set("gbt:lock", "other")
+
+	if err := lk.Release(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Release err = %v, want %v", err, ErrNotHeld)
+	}
+	mr.CheckGet(t, "gbt:lock", "other")
+}
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	mr := newTestRedis(t)[0]
+
+	lk, err := NewLocker().Obtain(ctx, "gbt:lock", time.Minute)
+	if err != nil {
+		t.Fatalf("Obtain err = %v", err)
+	}
+
+	if err := lk.Refresh(ctx, time.Hour); err != nil {
+		t.Fatalf("Refresh err = %v", err)
+	}
+	if ttl := mr.TTL("gbt:lock"); ttl != time.Hour {
+		t.Errorf("ttl = %v, want %v", ttl, time.Hour)
+	}
+	if ttl, err := lk.TTL(ctx); err != nil || ttl != time.Hour {
+		t.Errorf("TTL = %v, err = %v, want %v", ttl, err, time.Hour)
+	}
+
+	mr.Set("gbt:lock", "other")
+	if err := lk.Refresh(ctx, time.Hour); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Refresh lost lock err = %v, want %v", err, ErrNotHeld)
+	}
+	if _, err := lk.TTL(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("TTL lost lock err = %v, want %v", err, ErrNotHeld)
+	}
+}
+
+func TestAutoRefresh(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+	}{
+		{
+			name: "default interval",
+		},
+		{
+			name:     "interval not less than ttl",
+			interval: time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mr := newTestRedis(t)[0]
+
+			lk, err := NewLocker().Obtain(ctx, "gbt:lock", 300*time.Millisecond, Options{
+				AutoRefresh:     true,
+				RefreshInterval: tt.interval,
+			})
+			if err != nil {
+				t.Fatalf("Obtain err = %v", err)
+			}
+			defer lk.Release(ctx)
+
+			// lock is refreshed within ttl, so the shortened ttl is extended back
+			mr.SetTTL("gbt:lock", time.Millisecond)
+			time.Sleep(200 * time.Millisecond)
+			if ttl := mr.TTL("gbt:lock"); ttl != 300*time.Millisecond {
+				t.Errorf("ttl = %v, want refreshed to %v", ttl, 300*time.Millisecond)
+			}
+
+			// refresh fails once the lock is taken over
+			mr.Set("gbt:lock", "other")
+			select {
+			case <-lk.Lost():
+			case <-time.After(time.Second):
+				t.Fatal("Lost is not closed after the lock is taken over")
+			}
+			mr.CheckGet(t, "gbt:lock", "other")
+		})
+	}
+}
+
+func TestAutoRefreshStopOnRelease(t *testing.T) {
+	ctx := context.Background()
+	mr := newTestRedis(t)[0]
+
+	lk, err := NewLocker().Obtain(ctx, "gbt:lock", 300*time.Millisecond, Options{AutoRefresh: true})
+	if err != nil {
+		t.Fatalf("Obtain err = %v", err)
+	}
+	if err := lk.Release(ctx); err != nil {
+		t.Fatalf("Release err = %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if mr.Exists("gbt:lock") {
+		t.Error("released lock is refreshed")
+	}
+	select {
+	case <-lk.Lost():
+		t.Error("Lost is closed after Release")
+	default:
+	}
+}
+
+func TestRedlockQuorum(t *testing.T) {
+	ctx := context.Background()
+	conns := []string{"redis-lock-1", "redis-lock-2", "redis-lock-3"}
+
+	tests := []struct {
+		name    string
+		held    int
+		wantErr error
+	}{
+		{
+			name: "every instance is free",
+		},
+		{
+			name: "minority is held by others",
+			held: 1,
+		},
+		{
+			name:    "majority is held by others",
+			held:    2,
+			wantErr: ErrNotObtained,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mrs := newTestRedis(t, conns...)
+			for _, mr := range mrs[:tt.held] {
+				mr.Set("gbt:lock", "other")
+			}
+
+			lk, err := NewLocker(conns...).Obtain(ctx, "gbt:lock", time.Minute)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Obtain err = %v, want %v", err, tt.wantErr)
+			}
+
+			// instances held by others are kept, partially acquired instances are released on failure
+			for i, mr := range mrs {
+				switch {
+				case i < tt.held:
+					mr.CheckGet(t, "gbt:lock", "other")
+				case tt.wantErr != nil:
+					if mr.Exists("gbt:lock") {
+						t.Errorf("instance %d is not released", i)
+					}
+				default:
+					mr.CheckGet(t, "gbt:lock", lk.Token())
+				}
+			}
+		})
+	}
+}
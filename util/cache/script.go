@@ -0,0 +1,233 @@
+package cache
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	redisclient "github.com/golang-base-template/util/cache/client"
+)
+
+// name of embedded scripts, see scripts directory
+const (
+	ScriptCompareAndDelete = "compare_and_delete"
+	ScriptCompareAndSet    = "compare_and_set"
+	ScriptMSetEx           = "mset_ex"
+)
+
+type (
+	// Script is a registered lua script, it is executed with EVALSHA and falls back to EVAL
+	// when redis does not have the script (e.g. after restart or failover)
+	Script struct {
+		Name   string
+		script *redis.Script
+	}
+
+	// scriptCall is a script queued on pipeline, kept to retry it with EVAL on NOSCRIPT
+	scriptCall struct {
+		script *Script
+		cmd    *redis.Cmd
+		keys   []string
+		args   []interface{}
+	}
+)
+
+var (
+	// ErrScriptNotFound is returned when executing unregistered script
+	ErrScriptNotFound = errors.New("cache: script is not registered")
+	// ErrScriptInTx is returned when queueing script on transaction pipeline, its NOSCRIPT fallback
+	// would run outside MULTI/EXEC. Script is atomic by itself, run it with ICache EvalScript instead
+	ErrScriptInTx = errors.New("cache: script is not supported on transaction pipeline")
+
+	//go:embed scripts/*.lua
+	embeddedScripts embed.FS
+
+	scripts  = map[string]*Script{}
+	mxScript sync.RWMutex
+
+	// loadedScripts is set of script name loaded per connection
+	loadedScripts = map[string]map[string]bool{}
+)
+
+func init() {
+	if err := RegisterScripts(embeddedScripts, "scripts"); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterScript registers lua script by name, name must be unique
+func RegisterScript(name, src string) (*Script, error) {
+	mxScript.Lock()
+	defer mxScript.Unlock()
+
+	if _, exist := scripts[name]; exist {
+		return nil, errors.Errorf("[RegisterScript] script %s is already registered", name)
+	}
+
+	s := &Script{
+		Name:   name,
+		script: redis.NewScript(src),
+	}
+	scripts[name] = s
+	return s, nil
+}
+
+// RegisterScripts registers every .lua file in the directory of fsys (e.g. embed.FS) named by its file name
+func RegisterScripts(fsys fs.FS, dir string) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.lua"))
+	if err != nil {
+		return errors.Wrap(err, "[RegisterScripts]")
+	}
+
+	for _, file := range files {
+		src, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return errors.Wrapf(err, "[RegisterScripts] fail to read %s", file)
+		}
+
+		_, err = RegisterScript(strings.TrimSuffix(path.Base(file), ".lua"), string(src))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetScript return registered script
+func GetScript(name string) (*Script, error) {
+	mxScript.RLock()
+	defer mxScript.RUnlock()
+
+	s, ok := scripts[name]
+	if !ok {
+		return nil, errors.Wrapf(ErrScriptNotFound, "[GetScript] %s", name)
+	}
+	return s, nil
+}
+
+// Hash return sha1 of the script used by EVALSHA
+func (s *Script) Hash() string {
+	return s.script.Hash()
+}
+
+// load loads the script on the connection once, SCRIPT LOAD is skipped if it is already loaded
+func (s *Script) load(ctx context.Context, conn string, rds redisclient.Redis) error {
+	mxScript.RLock()
+	loaded := loadedScripts[conn][s.Name]
+	mxScript.RUnlock()
+	if loaded {
+		return nil
+	}
+
+	if err := s.script.Load(ctx, rds).Err(); err != nil {
+		return errors.Wrapf(err, "[Script][load] fail to load %s", s.Name)
+	}
+
+	mxScript.Lock()
+	if loadedScripts[conn] == nil {
+		loadedScripts[conn] = make(map[string]bool)
+	}
+	loadedScripts[conn][s.Name] = true
+	mxScript.Unlock()
+	return nil
+}
+
+// unload marks every script of the connection as not loaded, redis has lost its script cache
+func unloadScripts(conn string) {
+	mxScript.Lock()
+	delete(loadedScripts, conn)
+	mxScript.Unlock()
+}
+
+// isNoScript reports whether redis does not have the script
+func isNoScript(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT")
+}
+
+// EvalScript executes registered script with EVALSHA, nil reply is returned as nil result without error.
+// On cluster mode, every key must belong to the same hash slot.
+func (c *cacheClient) EvalScript(ctx context.Context, name string, keys []string, args ...interface{}) (res interface{}, err error) {
+	s, err := GetScript(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "[EvalScript]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return nil, errors.Wrap(err, "[EvalScript]")
+	}
+
+	if err = s.load(ctx, c.conn, rds); err != nil {
+		return nil, errors.Wrap(err, "[EvalScript]")
+	}
+
+	res, err = s.script.EvalSha(ctx, rds, keys, args...).Result()
+	if isNoScript(err) {
+		unloadScripts(c.conn)
+		res, err = s.script.Eval(ctx, rds, keys, args...).Result()
+	}
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return res, err
+}
+
+// EvalScript queues registered script with EVALSHA, it is retried with EVAL on Exec if redis does not have the script.
+// The script is loaded on the connection before it is queued. ErrScriptInTx is returned on transaction pipeline.
+func (cp *cachePipeline) EvalScript(ctx context.Context, name string, keys []string, args ...interface{}) (res *ScriptResult, err error) {
+
+	if cp.conn == "" || cp.pipe == nil {
+		return nil, errors.Wrap(ErrUninitialized, "[EvalScript]")
+	}
+	if cp.tx {
+		return nil, errors.Wrap(ErrScriptInTx, "[EvalScript]")
+	}
+
+	s, err := GetScript(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "[EvalScript]")
+	}
+
+	if err = s.load(ctx, cp.conn, cp.rdsConn); err != nil {
+		return nil, errors.Wrap(err, "[EvalScript]")
+	}
+
+	cmd := s.script.EvalSha(ctx, cp.pipe, keys, args...)
+	cp.scripts = append(cp.scripts, scriptCall{
+		script: s,
+		cmd:    cmd,
+		keys:   keys,
+		args:   args,
+	})
+
+	res = &ScriptResult{pipelineResult{cp}, cmd}
+	cp.cmdCount++
+	return
+}
+
+// evalNoScript retries queued scripts failed with NOSCRIPT using EVAL, then return first error of the pipeline
+func (cp *cachePipeline) evalNoScript(ctx context.Context, cmds []redis.Cmder) error {
+	for _, call := range cp.scripts {
+		if !isNoScript(call.cmd.Err()) {
+			continue
+		}
+
+		unloadScripts(cp.conn)
+		val, err := call.script.script.Eval(ctx, cp.rdsConn, call.keys, call.args...).Result()
+		call.cmd.SetVal(val)
+		call.cmd.SetErr(err)
+	}
+
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pkg/errors"
+
+	redisclient "github.com/golang-base-template/util/cache/client"
+)
+
+func TestEmbeddedScripts(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		prepare func(mr *miniredis.Miniredis)
+		script  string
+		keys    []string
+		args    []interface{}
+		want    interface{}
+		check   func(t *testing.T, mr *miniredis.Miniredis)
+	}{
+		{
+			name: "compare_and_delete same value",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:lock", "owner")
+			},
+			script: ScriptCompareAndDelete,
+			keys:   []string{"gbt:lock"},
+			args:   []interface{}{"owner"},
+			want:   int64(1),
+			check: func(t *testing.T, mr *miniredis.Miniredis) {
+				if mr.Exists("gbt:lock") {
+					t.Error("gbt:lock is not deleted")
+				}
+			},
+		},
+		{
+			name: "compare_and_delete other value",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:lock", "other")
+			},
+			script: ScriptCompareAndDelete,
+			keys:   []string{"gbt:lock"},
+			args:   []interface{}{"owner"},
+			want:   int64(0),
+			check: func(t *testing.T, mr *miniredis.Miniredis) {
+				mr.CheckGet(t, "gbt:lock", "other")
+			},
+		},
+		{
+			name:   "compare_and_delete missing key",
+			script: ScriptCompareAndDelete,
+			keys:   []string{"gbt:lock"},
+			args:   []interface{}{"owner"},
+			want:   int64(0),
+		},
+		{
+			name: "compare_and_set same value",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:k", "old")
+			},
+			script: ScriptCompareAndSet,
+			keys:   []string{"gbt:k"},
+			args:   []interface{}{"old", "new", 60000},
+			want:   int64(1),
+			check: func(t *testing.T, mr *miniredis.Miniredis) {
+				mr.CheckGet(t, "gbt:k", "new")
+				if ttl := mr.TTL("gbt:k"); ttl != time.Minute {
+					t.Errorf("ttl = %v, want %v", ttl, time.Minute)
+				}
+			},
+		},
+		{
+			name: "compare_and_set other value",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:k", "other")
+			},
+			script: ScriptCompareAndSet,
+			keys:   []string{"gbt:k"},
+			args:   []interface{}{"old", "new", 0},
+			want:   int64(0),
+			check: func(t *testing.T, mr *miniredis.Miniredis) {
+				mr.CheckGet(t, "gbt:k", "other")
+			},
+		},
+		{
+			name:   "compare_and_set empty expected value on missing key",
+			script: ScriptCompareAndSet,
+			keys:   []string{"gbt:k"},
+			args:   []interface{}{"", "new", 0},
+			want:   int64(1),
+			check: func(t *testing.T, mr *miniredis.Miniredis) {
+				mr.CheckGet(t, "gbt:k", "new")
+				if ttl := mr.TTL("gbt:k"); ttl != 0 {
+					t.Errorf("ttl = %v, want no expire", ttl)
+				}
+			},
+		},
+		{
+			name: "compare_and_set empty expected value on existing key",
+			prepare: func(mr *miniredis.Miniredis) {
+				mr.Set("gbt:k", "other")
+			},
+			script: ScriptCompareAndSet,
+			keys:   []string{"gbt:k"},
+			args:   []interface{}{"", "new", 0},
+			want:   int64(0),
+		},
+		{
+			name:   "mset_ex",
+			script: ScriptMSetEx,
+			keys:   []string{"gbt:a", "gbt:b"},
+			args:   []interface{}{60000, "1", "2"},
+			want:   int64(2),
+			check: func(t *testing.T, mr *miniredis.Miniredis) {
+				mr.CheckGet(t, "gbt:a", "1")
+				mr.CheckGet(t, "gbt:b", "2")
+				if ttl := mr.TTL("gbt:b"); ttl != time.Minute {
+					t.Errorf("ttl = %v, want %v", ttl, time.Minute)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestRedis(t)
+			if tt.prepare != nil {
+				tt.prepare(mr)
+			}
+
+			got, err := NewCache().EvalScript(ctx, tt.script, tt.keys, tt.args...)
+			if err != nil {
+				t.Fatalf("EvalScript err = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+			if tt.check != nil {
+				tt.check(t, mr)
+			}
+		})
+	}
+}
+
+func TestEvalScriptUnregistered(t *testing.T) {
+	newTestRedis(t)
+
+	_, err := NewCache().EvalScript(context.Background(), "unknown", []string{"gbt:k"})
+	if !errors.Is(err, ErrScriptNotFound) {
+		t.Errorf("err = %v, want %v", err, ErrScriptNotFound)
+	}
+}
+
+func TestEvalScriptLoaded(t *testing.T) {
+	ctx := context.Background()
+	newTestRedis(t)
+
+	rds, err := redisclient.GetConnection(CacheGBT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := GetScript(ScriptCompareAndDelete)
+
+	if _, err := NewCache().EvalScript(ctx, ScriptCompareAndDelete, []string{"gbt:lock"}, "owner"); err != nil {
+		t.Fatalf("EvalScript err = %v", err)
+	}
+
+	exists, err := rds.ScriptExists(ctx, s.Hash()).Result()
+	if err != nil || len(exists) != 1 || !exists[0] {
+		t.Fatalf("script is not loaded, exists = %v, err = %v", exists, err)
+	}
+
+	// next EvalScript skips SCRIPT LOAD and runs EVALSHA directly
+	mxScript.RLock()
+	loaded := loadedScripts[CacheGBT][ScriptCompareAndDelete]
+	mxScript.RUnlock()
+	if !loaded {
+		t.Error("script is not marked as loaded")
+	}
+}
+
+func TestEvalScriptNoScript(t *testing.T) {
+	ctx := context.Background()
+	mr := newTestRedis(t)
+	mr.Set("gbt:lock", "owner")
+
+	rds, err := redisclient.GetConnection(CacheGBT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCache()
+	if _, err := c.EvalScript(ctx, ScriptCompareAndDelete, []string{"gbt:other"}, "owner"); err != nil {
+		t.Fatalf("EvalScript err = %v", err)
+	}
+
+	// redis lost its script cache, e.g. after restart or failover
+	if err := rds.ScriptFlush(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.EvalScript(ctx, ScriptCompareAndDelete, []string{"gbt:lock"}, "owner")
+	if err != nil {
+		t.Fatalf("EvalScript after SCRIPT FLUSH err = %v", err)
+	}
+	if got != int64(1) {
+		t.Errorf("got %#v, want 1", got)
+	}
+	if mr.Exists("gbt:lock") {
+		t.Error("gbt:lock is not deleted")
+	}
+
+	mxScript.RLock()
+	_, loaded := loadedScripts[CacheGBT]
+	mxScript.RUnlock()
+	if loaded {
+		t.Error("scripts of the connection are still marked as loaded")
+	}
+}
+
+func TestPipelineEvalScriptNoScript(t *testing.T) {
+	ctx := context.Background()
+	mr := newTestRedis(t)
+	mr.Set("gbt:lock", "owner")
+
+	rds, err := redisclient.GetConnection(CacheGBT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewPkgPipeline().NewPipeline(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// script is loaded when it is queued
+	res, err := p.EvalScript(ctx, ScriptCompareAndDelete, []string{"gbt:lock"}, "owner")
+	if err != nil {
+		t.Fatalf("EvalScript err = %v", err)
+	}
+	incr, _ := p.Incr(ctx, "gbt:counter")
+
+	if err := rds.ScriptFlush(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Exec(ctx); err != nil {
+		t.Fatalf("Exec err = %v", err)
+	}
+
+	got, err := res.Int64()
+	if err != nil || got != 1 {
+		t.Errorf("script result = %d, err = %v, want 1", got, err)
+	}
+	if n, err := incr.Result(); err != nil || n != 1 {
+		t.Errorf("incr result = %d, err = %v, want 1", n, err)
+	}
+	if mr.Exists("gbt:lock") {
+		t.Error("gbt:lock is not deleted")
+	}
+}
+
+func TestTxPipelineEvalScript(t *testing.T) {
+	ctx := context.Background()
+	newTestRedis(t)
+
+	p, err := NewPkgPipeline().NewTxPipeline(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.EvalScript(ctx, ScriptCompareAndDelete, []string{"gbt:lock"}, "owner"); !errors.Is(err, ErrScriptInTx) {
+		t.Errorf("EvalScript on NewTxPipeline err = %v, want %v", err, ErrScriptInTx)
+	}
+
+	err = NewCache().Watch(ctx, []string{"gbt:lock"}, func(tx ICacheTx) error {
+		_, err := tx.TxPipeline(ctx).EvalScript(ctx, ScriptCompareAndDelete, []string{"gbt:lock"}, "owner")
+		return err
+	})
+	if !errors.Is(err, ErrScriptInTx) {
+		t.Errorf("EvalScript on Watch TxPipeline err = %v, want %v", err, ErrScriptInTx)
+	}
+}
@@ -0,0 +1,7 @@
+-- compare_and_delete deletes the key only if it still holds the expected value.
+-- KEYS[1] is the key, ARGV[1] is the expected value.
+-- Return 1 if the key is deleted, 0 otherwise
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
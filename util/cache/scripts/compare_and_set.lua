@@ -0,0 +1,15 @@
+-- compare_and_set sets the key only if it still holds the expected value, empty expected value means the key must not exist.
+-- KEYS[1] is the key, ARGV[1] is the expected value, ARGV[2] is the new value, ARGV[3] is ttl in ms (0 keeps no expire).
+-- Return 1 if the key is set, 0 otherwise
+local current = redis.call("GET", KEYS[1])
+if (current == false and ARGV[1] ~= "") or (current ~= false and current ~= ARGV[1]) then
+	return 0
+end
+
+local ttl = tonumber(ARGV[3])
+if ttl > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ttl)
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1
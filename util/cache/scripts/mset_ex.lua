@@ -0,0 +1,12 @@
+-- mset_ex sets every key with the same ttl atomically, keys must share the same hash slot on cluster mode.
+-- KEYS are the keys, ARGV[1] is ttl in ms (0 keeps no expire), ARGV[2..] are values in the same order as KEYS.
+-- Return number of keys set
+local ttl = tonumber(ARGV[1])
+for i, key in ipairs(KEYS) do
+	if ttl > 0 then
+		redis.call("SET", key, ARGV[i + 1], "PX", ttl)
+	else
+		redis.call("SET", key, ARGV[i + 1])
+	end
+end
+return #KEYS
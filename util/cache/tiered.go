@@ -0,0 +1,303 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/allegro/bigcache/v3"
+	"github.com/pkg/errors"
+
+	redisclient "github.com/golang-base-template/util/cache/client"
+	"github.com/golang-base-template/util/config"
+	"github.com/golang-base-template/util/metrics"
+)
+
+// versionStripes is number of key version stripes of tiered cache
+const versionStripes = 256
+
+type (
+	// ITieredCache is a local cache (L1) in front of redis (L2).
+	// Write and delete invalidate L1 of every pod, so stale local value is only possible until the broadcast arrives.
+	ITieredCache interface {
+		// Get read key from L1, then from L2 on miss. ErrCacheMiss is returned if key is missing on both
+		Get(ctx context.Context, key string) ([]byte, error)
+		// Set write key to L2 and L1, then invalidate L1 of other pods
+		Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+		// Delete delete keys from L2 and L1, then invalidate L1 of other pods
+		Delete(ctx context.Context, keys ...string) error
+		Close() error
+	}
+
+	tieredCache struct {
+		l1          IBigCache
+		l2          ICache
+		invalidator IInvalidator
+		// l1TTL is max ttl of L1 entry, zero means life window of L1
+		l1TTL time.Duration
+
+		// versions guards L1 write of value read from L2 against invalidation of the same key in between,
+		// keys are striped so memory does not grow with number of keys
+		versions [versionStripes]keyVersion
+	}
+
+	// keyVersion is bumped on every L1 write and invalidation of its keys
+	keyVersion struct {
+		mx      sync.Mutex
+		version uint64
+	}
+
+	// invalidation is payload broadcast on write and delete
+	invalidation struct {
+		Origin string   `json:"origin"`
+		Keys   []string `json:"keys"`
+	}
+)
+
+// NewTieredCache return tiered cache configured by TieredCache config of given name
+func NewTieredCache(name string) (ITieredCache, error) {
+	conf, ok := config.Get().TieredCache[name]
+	if !ok || conf == nil {
+		return nil, errors.Errorf("[NewTieredCache] tiered cache %s is not configured", name)
+	}
+
+	l1, err := NewBigCache(conf.LocalCache)
+	if err != nil {
+		return nil, errors.Wrap(err, "[NewTieredCache]")
+	}
+
+	redisConn := conf.Redis
+	if redisConn == "" {
+		redisConn = CacheGBT
+	}
+
+	channel := conf.InvalidationChannel
+	if channel == "" {
+		channel = "cache-invalidation-" + name
+	}
+
+	var invalidator IInvalidator
+	switch conf.Invalidation {
+	case InvalidationNSQ:
+		invalidator = NewNSQInvalidator(conf.NSQ, channel)
+	case InvalidationRedis, "":
+		invalidator = NewRedisInvalidator(redisConn, channel)
+	default:
+		return nil, errors.Errorf("[NewTieredCache] unknown invalidation backend %s", conf.Invalidation)
+	}
+
+	return newTieredCache(l1, NewCache(redisConn), invalidator, time.Duration(conf.LocalTTLSec)*time.Second)
+}
+
+// NewTieredCacheWith return tiered cache from given layers and start listening to invalidation,
+// L1 entry lives up to its redis key ttl and the life window of L1
+func NewTieredCacheWith(l1 IBigCache, l2 ICache, invalidator IInvalidator) (ITieredCache, error) {
+	return newTieredCache(l1, l2, invalidator, 0)
+}
+
+func newTieredCache(l1 IBigCache, l2 ICache, invalidator IInvalidator, l1TTL time.Duration) (ITieredCache, error) {
+	tc := &tieredCache{
+		l1:          l1,
+		l2:          l2,
+		invalidator: invalidator,
+		l1TTL:       l1TTL,
+	}
+
+	err := invalidator.Subscribe(tc.onInvalidation, tc.onReset)
+	if err != nil {
+		return nil, errors.Wrap(err, "[NewTieredCache] fail to subscribe invalidation")
+	}
+
+	return tc, nil
+}
+
+func (tc *tieredCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if key == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[TieredCache][Get]")
+	}
+
+	value, err := tc.l1.Get(key)
+	if err == nil {
+		metrics.IncrCounter("cache.local.hit", 1, redisclient.KeyPrefix(key))
+		return value, nil
+	}
+	metrics.IncrCounter("cache.local.miss", 1, redisclient.KeyPrefix(key))
+	if err != bigcache.ErrEntryNotFound {
+		log.Printf("[TieredCache][Get] fail to get %s from local cache, err: %v", key, err)
+	}
+
+	// version is taken before L2 read, so value invalidated while it is read is not written to L1
+	version := tc.version(key)
+	res, err := tc.l2.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	value = []byte(res)
+
+	ttl, err := tc.l2.TTL(ctx, key)
+	if err != nil {
+		log.Printf("[TieredCache][Get] fail to get ttl of %s, err: %v", key, err)
+		return value, nil
+	}
+	// -2 is returned when the key expires right after it is read
+	if ttl == -2 {
+		return value, nil
+	}
+
+	if err := tc.fill(key, value, ttl, version); err != nil {
+		log.Printf("[TieredCache][Get] fail to set %s to local cache, err: %v", key, err)
+	}
+
+	return value, nil
+}
+
+func (tc *tieredCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if key == "" || len(value) == 0 {
+		return errors.Wrap(ErrInvalidKeyorField, "[TieredCache][Set]")
+	}
+
+	err := tc.l2.Set(ctx, key, string(value), ttl)
+	if err != nil {
+		return errors.Wrap(err, "[TieredCache][Set]")
+	}
+
+	if err := tc.invalidate(key, value, ttl); err != nil {
+		log.Printf("[TieredCache][Set] fail to set %s to local cache, err: %v", key, err)
+	}
+
+	return tc.publish(ctx, key)
+}
+
+func (tc *tieredCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return errors.Wrap(ErrInvalidKeyorField, "[TieredCache][Delete]")
+	}
+
+	_, err := tc.l2.Del(ctx, keys...)
+	if err != nil {
+		return errors.Wrap(err, "[TieredCache][Delete]")
+	}
+
+	for _, key := range keys {
+		if err := tc.invalidate(key, nil, 0); err != nil {
+			log.Printf("[TieredCache][Delete] fail to delete %s from local cache, err: %v", key, err)
+		}
+	}
+
+	return tc.publish(ctx, keys...)
+}
+
+func (tc *tieredCache) Close() error {
+	return tc.invalidator.Close()
+}
+
+// publish broadcast invalidation of keys to other pods
+func (tc *tieredCache) publish(ctx context.Context, keys ...string) error {
+	payload, err := json.Marshal(invalidation{
+		Origin: instanceID,
+		Keys:   keys,
+	})
+	if err != nil {
+		return errors.Wrap(err, "[TieredCache][publish] fail to encode invalidation")
+	}
+
+	err = tc.invalidator.Publish(ctx, payload)
+	if err != nil {
+		return errors.Wrap(err, "[TieredCache][publish] fail to publish invalidation")
+	}
+	return nil
+}
+
+// onInvalidation drop invalidated keys from local cache, own broadcast is skipped since L1 is already up to date
+func (tc *tieredCache) onInvalidation(payload []byte) {
+	inv := invalidation{}
+	if err := json.Unmarshal(payload, &inv); err != nil {
+		log.Printf("[TieredCache][onInvalidation] fail to decode invalidation, err: %v", err)
+		return
+	}
+
+	if inv.Origin == instanceID {
+		return
+	}
+
+	for _, key := range inv.Keys {
+		if err := tc.invalidate(key, nil, 0); err != nil {
+			log.Printf("[TieredCache][onInvalidation] fail to delete %s from local cache, err: %v", key, err)
+		}
+	}
+}
+
+// onReset purges L1 since invalidation may have been missed, every key version is bumped
+// so value read from L2 before the purge is not written to L1
+func (tc *tieredCache) onReset() {
+	for i := range tc.versions {
+		tc.versions[i].mx.Lock()
+	}
+	defer func() {
+		for i := range tc.versions {
+			tc.versions[i].mx.Unlock()
+		}
+	}()
+
+	for i := range tc.versions {
+		tc.versions[i].version++
+	}
+	if err := tc.l1.Reset(); err != nil {
+		log.Printf("[TieredCache][onReset] fail to reset local cache, err: %v", err)
+	}
+}
+
+// keyVersion return version stripe of the key
+func (tc *tieredCache) keyVersion(key string) *keyVersion {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &tc.versions[h.Sum32()%versionStripes]
+}
+
+// version return current version of the key
+func (tc *tieredCache) version(key string) uint64 {
+	kv := tc.keyVersion(key)
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+	return kv.version
+}
+
+// fill writes value read from L2 to L1 only if the key is not written nor invalidated since version is taken
+func (tc *tieredCache) fill(key string, value []byte, ttl time.Duration, version uint64) error {
+	kv := tc.keyVersion(key)
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+
+	if kv.version != version {
+		return nil
+	}
+	return tc.l1.SetWithTTL(key, value, tc.localTTL(ttl))
+}
+
+// invalidate bumps version of the key, then writes value to L1 or deletes it from L1 if value is nil
+func (tc *tieredCache) invalidate(key string, value []byte, ttl time.Duration) error {
+	kv := tc.keyVersion(key)
+	kv.mx.Lock()
+	defer kv.mx.Unlock()
+
+	kv.version++
+	if value == nil {
+		return tc.l1.Delete(key)
+	}
+	return tc.l1.SetWithTTL(key, value, tc.localTTL(ttl))
+}
+
+// localTTL return ttl of L1 entry, the smaller of redis key ttl and L1 max ttl.
+// Zero or negative ttl means the redis key has no expire.
+func (tc *tieredCache) localTTL(ttl time.Duration) time.Duration {
+	if tc.l1TTL > 0 && (ttl <= 0 || ttl > tc.l1TTL) {
+		return tc.l1TTL
+	}
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
@@ -0,0 +1,303 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/allegro/bigcache/v3"
+	"github.com/pkg/errors"
+)
+
+// readHookCache calls onGet after key is read from redis, before tiered cache fills L1 with it
+type readHookCache struct {
+	ICache
+	onGet func()
+}
+
+func (c *readHookCache) Get(ctx context.Context, key string) (string, error) {
+	res, err := c.ICache.Get(ctx, key)
+	if c.onGet != nil {
+		c.onGet()
+	}
+	return res, err
+}
+
+// newTestTieredCache return tiered cache on CacheGBT miniredis, its L2 calls onGet after every read
+func newTestTieredCache(t *testing.T, l1TTL time.Duration, onGet func()) (*tieredCache, IBigCache) {
+	l1, err := NewBigCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc, err := newTieredCache(l1, &readHookCache{ICache: NewCache(), onGet: onGet}, NewRedisInvalidator(CacheGBT, "gbt:test:invalidation"), l1TTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		tc.Close()
+	})
+	return tc.(*tieredCache), l1
+}
+
+// otherPodInvalidation return invalidation payload of keys published by other pod
+func otherPodInvalidation(t *testing.T, keys ...string) []byte {
+	payload, err := json.Marshal(invalidation{Origin: "other-pod", Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestTieredCacheGet(t *testing.T) {
+	ctx := context.Background()
+	mr := newTestRedis(t)
+	tc, l1 := newTestTieredCache(t, 0, nil)
+
+	if _, err := tc.Get(ctx, "gbt:k"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("Get miss err = %v, want %v", err, ErrCacheMiss)
+	}
+	if _, err := l1.Get("gbt:k"); err != bigcache.ErrEntryNotFound {
+		t.Errorf("L1 err after miss = %v, want %v", err, bigcache.ErrEntryNotFound)
+	}
+
+	mr.Set("gbt:k", "v1")
+	if got, err := tc.Get(ctx, "gbt:k"); err != nil || string(got) != "v1" {
+		t.Fatalf("Get = %q, err = %v, want v1", got, err)
+	}
+
+	// L1 serves the value until it is invalidated
+	mr.Set("gbt:k", "v2")
+	if got, err := tc.Get(ctx, "gbt:k"); err != nil || string(got) != "v1" {
+		t.Errorf("Get from L1 = %q, err = %v, want v1", got, err)
+	}
+}
+
+func TestTieredCacheSetDelete(t *testing.T) {
+	ctx := context.Background()
+	mr := newTestRedis(t)
+	tc, l1 := newTestTieredCache(t, 0, nil)
+
+	if err := tc.Set(ctx, "gbt:k", []byte("v"), time.Minute); err != nil {
+		t.Fatalf("Set err = %v", err)
+	}
+	mr.CheckGet(t, "gbt:k", "v")
+	if ttl := mr.TTL("gbt:k"); ttl != time.Minute {
+		t.Errorf("ttl = %v, want %v", ttl, time.Minute)
+	}
+	if got, err := l1.Get("gbt:k"); err != nil || string(got) != "v" {
+		t.Errorf("L1 = %q, err = %v, want v", got, err)
+	}
+
+	if err := tc.Delete(ctx, "gbt:k"); err != nil {
+		t.Fatalf("Delete err = %v", err)
+	}
+	if mr.Exists("gbt:k") {
+		t.Error("gbt:k is not deleted from redis")
+	}
+	if _, err := l1.Get("gbt:k"); err != bigcache.ErrEntryNotFound {
+		t.Errorf("L1 err = %v, want %v", err, bigcache.ErrEntryNotFound)
+	}
+}
+
+func TestTieredCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	mr := newTestRedis(t)
+	tc, l1 := newTestTieredCache(t, 0, nil)
+
+	mr.Set("gbt:k", "v")
+	mr.Set("gbt:other", "v")
+	for _, key := range []string{"gbt:k", "gbt:other"} {
+		if _, err := tc.Get(ctx, key); err != nil {
+			t.Fatalf("Get err = %v", err)
+		}
+	}
+
+	// waitInvalidated waits until key is dropped from L1
+	waitInvalidated := func(key string) {
+		deadline := time.Now().Add(time.Second)
+		for {
+			if _, err := l1.Get(key); err == bigcache.ErrEntryNotFound {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s is not invalidated from L1 by other pod", key)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// own broadcast is skipped since L1 is already up to date, payloads are received in order
+	own, err := json.Marshal(invalidation{Origin: instanceID, Keys: []string{"gbt:k"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.invalidator.Publish(ctx, own); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.invalidator.Publish(ctx, otherPodInvalidation(t, "gbt:other")); err != nil {
+		t.Fatal(err)
+	}
+	waitInvalidated("gbt:other")
+	if _, err := l1.Get("gbt:k"); err != nil {
+		t.Errorf("gbt:k is invalidated by own broadcast, err: %v", err)
+	}
+
+	if err := tc.invalidator.Publish(ctx, otherPodInvalidation(t, "gbt:k")); err != nil {
+		t.Fatal(err)
+	}
+	waitInvalidated("gbt:k")
+}
+
+func TestTieredCacheFillInvalidatedDuringRead(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		onGet    func(tc *tieredCache)
+		wantFill bool
+	}{
+		{
+			name:     "no invalidation",
+			onGet:    func(tc *tieredCache) {},
+			wantFill: true,
+		},
+		{
+			name: "invalidated by other pod",
+			onGet: func(tc *tieredCache) {
+				tc.onInvalidation(otherPodInvalidation(t, "gbt:k"))
+			},
+		},
+		{
+			name: "deleted by this pod",
+			onGet: func(tc *tieredCache) {
+				tc.invalidate("gbt:k", nil, 0)
+			},
+		},
+		{
+			name: "reset after resubscribe",
+			onGet: func(tc *tieredCache) {
+				tc.onReset()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := newTestRedis(t)
+			mr.Set("gbt:k", "v")
+
+			var tc *tieredCache
+			tc, l1 := newTestTieredCache(t, 0, func() {
+				tt.onGet(tc)
+			})
+
+			// the value read before invalidation is still returned to the caller
+			if got, err := tc.Get(ctx, "gbt:k"); err != nil || string(got) != "v" {
+				t.Fatalf("Get = %q, err = %v, want v", got, err)
+			}
+
+			_, err := l1.Get("gbt:k")
+			if filled := err == nil; filled != tt.wantFill {
+				t.Errorf("L1 filled = %v, want %v", filled, tt.wantFill)
+			}
+		})
+	}
+}
+
+func TestTieredCacheReset(t *testing.T) {
+	ctx := context.Background()
+	newTestRedis(t)
+	tc, l1 := newTestTieredCache(t, 0, nil)
+
+	for _, key := range []string{"gbt:a", "gbt:b"} {
+		if err := tc.Set(ctx, key, []byte("v"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tc.onReset()
+	if n := l1.Len(); n != 0 {
+		t.Errorf("L1 has %d entries after reset, want 0", n)
+	}
+}
+
+func TestTieredCacheLocalTTL(t *testing.T) {
+	tests := []struct {
+		name  string
+		l1TTL time.Duration
+		ttl   time.Duration
+		want  time.Duration
+	}{
+		{name: "redis ttl without L1 ttl", ttl: time.Minute, want: time.Minute},
+		{name: "no expire without L1 ttl", ttl: -1, want: 0},
+		{name: "redis ttl shorter than L1 ttl", l1TTL: time.Hour, ttl: time.Minute, want: time.Minute},
+		{name: "redis ttl longer than L1 ttl", l1TTL: time.Minute, ttl: time.Hour, want: time.Minute},
+		{name: "no expire with L1 ttl", l1TTL: time.Minute, ttl: -1, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &tieredCache{l1TTL: tt.l1TTL}
+			if got := tc.localTTL(tt.ttl); got != tt.want {
+				t.Errorf("localTTL(%v) = %v, want %v", tt.ttl, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedisInvalidatorResubscribe(t *testing.T) {
+	ctx := context.Background()
+	mr := newTestRedis(t)
+
+	var (
+		received = make(chan string, 100)
+		resets   int32
+	)
+	ri := NewRedisInvalidator(CacheGBT, "gbt:test:invalidation")
+	err := ri.Subscribe(func(payload []byte) {
+		received <- string(payload)
+	}, func() {
+		atomic.AddInt32(&resets, 1)
+	})
+	if err != nil {
+		t.Fatalf("Subscribe err = %v", err)
+	}
+	defer ri.Close()
+
+	if err := ri.Publish(ctx, []byte("before")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "before" {
+		t.Fatalf("received %q, want before", got)
+	}
+	if n := atomic.LoadInt32(&resets); n != 0 {
+		t.Fatalf("reset is called %d times before the subscription is lost", n)
+	}
+
+	// subscription is lost with the connection, payload published until it is recovered is missed
+	mr.Close()
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(5 * time.Second)
+	for {
+		mr.Publish("gbt:test:invalidation", "after")
+		select {
+		case got := <-received:
+			if got != "after" {
+				t.Fatalf("received %q, want after", got)
+			}
+			if n := atomic.LoadInt32(&resets); n != 1 {
+				t.Errorf("reset is called %d times after resubscribe, want 1", n)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("subscription is not recovered")
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	redisclient "github.com/golang-base-template/util/cache/client"
+)
+
+type (
+	// ICacheTx reads watched keys and queues the writes of optimistic transaction, see ICache Watch
+	ICacheTx interface {
+		Get(ctx context.Context, key string) (res string, err error)
+		GetValue(ctx context.Context, key string, dst interface{}) (err error)
+		Exists(ctx context.Context, key string) (res bool, err error)
+		HGet(ctx context.Context, key, field string) (res string, err error)
+		HGetAll(ctx context.Context, key string) (res map[string]string, err error)
+		// TxPipeline return transaction pipeline on the watched connection, its Exec fails with ErrTxFailed
+		// if any watched key is changed after Watch. Script can not be queued on it, see ErrScriptInTx
+		TxPipeline(ctx context.Context) ICachePipeline
+	}
+
+	cacheTx struct {
+		conn string
+		rds  redisclient.Redis
+		tx   *redis.Tx
+	}
+)
+
+var (
+	// MaxWatchRetries is number of attempts of Watch before ErrTxFailed is returned
+	MaxWatchRetries = 10
+)
+
+// Watch runs fn in optimistic transaction: keys are watched, fn reads them with tx and queues the writes on
+// tx.TxPipeline which is executed atomically on Exec. fn is retried when a watched key is changed by other client
+// before Exec, up to MaxWatchRetries times. On cluster mode, every key must belong to the same hash slot.
+func (c *cacheClient) Watch(ctx context.Context, keys []string, fn func(tx ICacheTx) error) (err error) {
+	if len(keys) == 0 || fn == nil {
+		return errors.Wrap(ErrInvalidKeyorField, "[Watch]")
+	}
+
+	rds, err := c.client()
+	if err != nil {
+		return errors.Wrap(err, "[Watch]")
+	}
+
+	for i := 0; i < MaxWatchRetries; i++ {
+		err = rds.Watch(ctx, func(tx *redis.Tx) error {
+			return fn(&cacheTx{
+				conn: c.conn,
+				rds:  rds,
+				tx:   tx,
+			})
+		}, keys...)
+		if !errors.Is(err, ErrTxFailed) {
+			return err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return errors.Wrapf(err, "[Watch] watched keys are changed after %d attempts", MaxWatchRetries)
+}
+
+func (t *cacheTx) Get(ctx context.Context, key string) (res string, err error) {
+	if key == "" {
+		return "", errors.Wrap(ErrInvalidKeyorField, "[Tx][Get]")
+	}
+	return stringReply(t.tx.Get(ctx, key).Result())
+}
+
+func (t *cacheTx) GetValue(ctx context.Context, key string, dst interface{}) (err error) {
+	if key == "" {
+		return errors.Wrap(ErrInvalidKeyorField, "[Tx][GetValue]")
+	}
+
+	data, err := t.tx.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return ErrCacheMiss
+	}
+	if err != nil {
+		return err
+	}
+
+	return Decode(data, dst)
+}
+
+func (t *cacheTx) Exists(ctx context.Context, key string) (res bool, err error) {
+	if key == "" {
+		return false, errors.Wrap(ErrInvalidKeyorField, "[Tx][Exists]")
+	}
+
+	n, err := t.tx.Exists(ctx, key).Result()
+	return n > 0, err
+}
+
+func (t *cacheTx) HGet(ctx context.Context, key, field string) (res string, err error) {
+	if key == "" || field == "" {
+		return "", errors.Wrap(ErrInvalidKeyorField, "[Tx][HGet]")
+	}
+	return stringReply(t.tx.HGet(ctx, key, field).Result())
+}
+
+func (t *cacheTx) HGetAll(ctx context.Context, key string) (res map[string]string, err error) {
+	if key == "" {
+		return nil, errors.Wrap(ErrInvalidKeyorField, "[Tx][HGetAll]")
+	}
+	return t.tx.HGetAll(ctx, key).Result()
+}
+
+func (t *cacheTx) TxPipeline(ctx context.Context) ICachePipeline {
+	return &cachePipeline{
+		conn:    t.conn,
+		rdsConn: t.rds,
+		pipe:    t.tx.TxPipeline(),
+		tx:      true,
+		results: make(map[ResultType][]resulter),
+	}
+}
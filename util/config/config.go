@@ -25,12 +25,17 @@ type (
 	Config struct {
 		ServiceName  string
 		Port         PortConfig
-		Database     map[string]*DatabaseConf `json:"database"`
-		Redis        map[string]*RedisConf    `json:"redis"`
-		Nsq          map[string]*NSQConfig    `yaml:"nsq"`
+		HTTP         HTTPConfig                  `yaml:"http"`
+		Database     map[string]*DatabaseConf    `json:"database"`
+		Redis        map[string]*RedisConf       `json:"redis"`
+		BigCache     map[string]*BigCacheConf    `yaml:"bigcache"`
+		TieredCache  map[string]*TieredCacheConf `yaml:"tiered-cache"`
+		RateLimit    map[string]*RateLimitConf   `yaml:"ratelimit"`
+		Nsq          map[string]*NSQConfig       `yaml:"nsq"`
 		Consumer     ConsumerConfig
 		ConsumerList map[string]*ConsumerListConfig `yaml:"ConsumerList"`
 		URL          URLConfig
+		Debug        DebugConfig `yaml:"debug"`
 	}
 
 	// PortConfig is config for app port
@@ -40,6 +45,13 @@ type (
 		Bg   string
 	}
 
+	// HTTPConfig is config of http routes
+	HTTPConfig struct {
+		// RequestTimeoutMS is deadline of request context, redis command issued with it fails after the deadline.
+		// Default 5000
+		RequestTimeoutMS int `yaml:"request-timeout-ms"`
+	}
+
 	// DatabaseConf is config for database
 	DatabaseConf struct {
 		MasterMaxConn int    `gcfg:"master-conn"`
@@ -56,14 +68,77 @@ type (
 	}
 	// RedisConf is config for redis
 	RedisConf struct {
-		Address          string `gcfg:"address"`
-		PoolSize         int    `gcfg:"pool-size"`
-		PoolTimeoutMS    int    `gcfg:"pool-timeout"`
-		DialTimeoutMS    int    `gcfg:"dial-timeout"`
-		ReadTimeoutMS    int    `gcfg:"read-timeout"`
-		WriteTimeoutMS   int    `gcfg:"write-timeout"`
-		IdleTimeoutSec   int    `gcfg:"idle-timeout-sec"`
-		IdleFreqCheckSec int    `gcfg:"idle-frequency-check-sec"`
+		// Mode is redis topology, either "standalone" (default), "sentinel" or "cluster"
+		Mode string `gcfg:"mode" yaml:"mode"`
+		// Address is used by standalone mode, Addresses is used by sentinel (sentinel addresses) and cluster (seed nodes)
+		Address   string   `gcfg:"address"`
+		Addresses []string `gcfg:"addresses" yaml:"addresses"`
+		// MasterName is master set name monitored by sentinel
+		MasterName string `gcfg:"master-name" yaml:"master-name"`
+		Password   string `gcfg:"password" yaml:"password"`
+		// DB is database index, ignored on cluster mode
+		DB int `gcfg:"db" yaml:"db"`
+		// TLS enables tls connection, TLSSkipVerify skips server certificate verification
+		TLS           bool `gcfg:"tls" yaml:"tls"`
+		TLSSkipVerify bool `gcfg:"tls-skip-verify" yaml:"tls-skip-verify"`
+		// Degrade is pipeline behavior while redis is unavailable, either "error" (default) or "noop".
+		// On noop, read is a cache miss, fire-and-forget write (e.g. SET, DEL) is dropped and other command fails
+		Degrade string `gcfg:"degrade" yaml:"degrade"`
+		// BreakerThreshold is consecutive failures that open the circuit breaker (default 5),
+		// BreakerCooldownMS is how long it stays open before a probe command is let through (default 1000)
+		BreakerThreshold  int `gcfg:"breaker-threshold" yaml:"breaker-threshold"`
+		BreakerCooldownMS int `gcfg:"breaker-cooldown" yaml:"breaker-cooldown"`
+
+		PoolSize         int `gcfg:"pool-size"`
+		PoolTimeoutMS    int `gcfg:"pool-timeout"`
+		DialTimeoutMS    int `gcfg:"dial-timeout"`
+		ReadTimeoutMS    int `gcfg:"read-timeout"`
+		WriteTimeoutMS   int `gcfg:"write-timeout"`
+		IdleTimeoutSec   int `gcfg:"idle-timeout-sec"`
+		IdleFreqCheckSec int `gcfg:"idle-frequency-check-sec"` // unused, idle connection is reaped by the client
+	}
+	// BigCacheConf is config for local in-memory cache
+	BigCacheConf struct {
+		Shards             int `yaml:"shards"`
+		LifeWindowSec      int `yaml:"life-window-sec"`
+		CleanWindowSec     int `yaml:"clean-window-sec"`
+		MaxEntriesInWindow int `yaml:"max-entries-in-window"`
+		MaxEntrySizeByte   int `yaml:"max-entry-size-byte"`
+		HardMaxCacheSizeMB int `yaml:"hard-max-cache-size-mb"`
+		// StatsEnabled counts Get per key, see IBigCache KeyStats
+		StatsEnabled bool `yaml:"stats-enabled"`
+	}
+	// TieredCacheConf is config for local cache (L1) in front of redis (L2)
+	TieredCacheConf struct {
+		Redis      string `yaml:"redis"`
+		LocalCache string `yaml:"local-cache"`
+		// LocalTTLSec is max ttl of L1 entry, L1 entry never outlives its redis key. Default to life window of LocalCache
+		LocalTTLSec int `yaml:"local-ttl-sec"`
+		// Invalidation is backend used to broadcast L1 invalidation to all pods, either "redis" (pub/sub) or "nsq"
+		Invalidation string `yaml:"invalidation"`
+		// InvalidationChannel is redis pub/sub channel or nsq topic used for invalidation
+		InvalidationChannel string `yaml:"invalidation-channel"`
+		// NSQ is publisher connection used when invalidation backend is nsq
+		NSQ string `yaml:"nsq"`
+	}
+	// RateLimitConf is config for request quota of a rule
+	RateLimitConf struct {
+		// Algorithm is either "sliding-window" (default) or "token-bucket"
+		Algorithm string `yaml:"algorithm"`
+		// Limit is number of requests allowed per window
+		Limit     int `yaml:"limit"`
+		WindowSec int `yaml:"window-sec"`
+		// Burst is bucket capacity of token bucket, default to Limit
+		Burst int `yaml:"burst"`
+		// KeyBy identifies the client, either "ip" (default) or "header:<name>" (e.g. "header:X-User-ID")
+		KeyBy string `yaml:"key-by"`
+		// TrustedProxies is ip or cidr of proxies whose X-Forwarded-For is trusted to find client ip,
+		// X-Forwarded-For is ignored if it is empty
+		TrustedProxies []string `yaml:"trusted-proxies"`
+		Redis          string   `yaml:"redis"`
+		// LocalCache is bigcache config used to limit locally (per pod) when redis is unavailable,
+		// its life-window-sec should be at least twice of window-sec
+		LocalCache string `yaml:"local-cache"`
 	}
 	//ConsumerConfig contains default configuration for nsq consumers
 	ConsumerConfig struct {
@@ -72,6 +147,29 @@ type (
 		DefaultMaxAttempts  uint16
 		MaxBackoffDuration  int
 		DefaultRequeueDelay int
+		// ShutdownTimeoutSec is how long gbt_bg waits for in-flight messages on shutdown, default 30
+		ShutdownTimeoutSec int
+	}
+
+	// RetryConfig is retry policy of a consumer. Delay of attempt n is BaseDelayMS * Multiplier^(n-1)
+	// capped at MaxDelayMS, then reduced by random fraction up to Jitter (0 to 1)
+	RetryConfig struct {
+		MaxAttempts int     `yaml:"MaxAttempts"`
+		BaseDelayMS int     `yaml:"BaseDelayMS"`
+		Multiplier  float64 `yaml:"Multiplier"`
+		MaxDelayMS  int     `yaml:"MaxDelayMS"`
+		Jitter      float64 `yaml:"Jitter"`
+	}
+
+	// IdempotencyConfig is processing state of consumer messages recorded in redis
+	IdempotencyConfig struct {
+		// Redis is redis connection of the state, default to redis-gbt
+		Redis string `yaml:"Redis"`
+		// KeyField is dot separated json field of the body identifying the message (e.g. "payload.order_id"),
+		// default to message ID
+		KeyField string `yaml:"KeyField"`
+		// TTLSec is how long handled message is remembered, default 86400
+		TTLSec int `yaml:"TTLSec"`
 	}
 
 	//ConsumerListConfig shall only be used by consumer package
@@ -82,6 +180,39 @@ type (
 		WorkerAmount int    `yaml:"WorkerAmount"`
 		MaxInFlight  int    `yaml:"MaxInFlight"`
 
+		// Backend is queue backend, either "nsq" (default) or "redis-stream".
+		// On redis-stream, Topic is the stream, Channel is the consumer group and MaxInFlight is messages read at once.
+		Backend string `yaml:"Backend"`
+		// Redis is redis connection of redis-stream backend
+		Redis string `yaml:"Redis"`
+		// MaxDeliveries moves redis-stream message to DeadLetter stream after this many deliveries, default to Consumer DefaultMaxAttempts
+		MaxDeliveries int `yaml:"MaxDeliveries"`
+		// ClaimMinIdleSec is how long redis-stream message stays pending before it is delivered again, e.g. after its worker crashed
+		ClaimMinIdleSec int `yaml:"ClaimMinIdleSec"`
+		// DeadLetter is redis-stream dead letter stream, default to <Topic>:dead-letter
+		DeadLetter string `yaml:"DeadLetter"`
+		// DLQ is nsq topic of messages failing permanently or on their last attempt, default to <Topic>.dlq
+		DLQ string `yaml:"DLQ"`
+		// MsgTimeoutSec is nsq msg timeout of the consumer, default to nsqd msg timeout (60)
+		MsgTimeoutSec int `yaml:"MsgTimeoutSec"`
+		// HandlerTimeoutSec is deadline of message context, default to msg timeout (ClaimMinIdleSec on redis-stream)
+		HandlerTimeoutSec int `yaml:"HandlerTimeoutSec"`
+		// Idempotency skips redelivered or duplicate message which is already handled, it is disabled if not set
+		Idempotency *IdempotencyConfig `yaml:"Idempotency"`
+		// Middlewares is middleware chain of the consumer by registered name, the first is the outermost,
+		// e.g. [logging, metrics, tracing, ratelimit, idempotency], default to idempotency.
+		// Panic recovery and handler timeout are always applied outside the chain
+		Middlewares []string `yaml:"Middlewares"`
+		// RateLimit is RateLimit rule name used by ratelimit middleware
+		RateLimit string `yaml:"RateLimit"`
+		// BatchSize enables batch mode of BatchHandler, up to BatchSize messages are handled at once
+		// after the first one waits for BatchWaitMS (default 100), MaxInFlight is raised to BatchSize.
+		// It can not be combined with Middlewares, Idempotency nor RateLimit since they are per message
+		BatchSize   int `yaml:"BatchSize"`
+		BatchWaitMS int `yaml:"BatchWaitMS"`
+		// Retry is retry policy of the consumer, Consumer DefaultRequeueDelay and MaxBackoffDuration are used if it is not set
+		Retry *RetryConfig `yaml:"Retry"`
+
 		Handler nsq.Handler
 		Config  *nsq.Config
 	}
@@ -94,6 +225,16 @@ type (
 	URLConfig struct {
 		ExampleExternalService string
 	}
+
+	// DebugConfig is config of debug endpoints, they are disabled by default
+	DebugConfig struct {
+		// CacheKeys exposes /debug/cache/keys to list registered cache keys and invalidate them
+		CacheKeys bool `yaml:"cache-keys"`
+		// Vars exposes expvar metrics on /debug/vars, including cache metrics, cmdline and memstats
+		Vars bool `yaml:"vars"`
+		// Token is bearer token required by debug endpoints, every request is rejected if it is empty
+		Token string `yaml:"token"`
+	}
 )
 
 func InitConfig() error {
@@ -130,3 +130,22 @@ func GetConnection(name string) (database DatabaseConf, err error) {
 
 	return DatabaseConf{}, fmt.Errorf("Database connection " + name + " doesn't exist")
 }
+
+// CloseDB closes every master and slave connection pool
+func CloseDB() (err error) {
+	for name, db := range dbConn {
+		if db.Master != nil {
+			if errClose := db.Master.Close(); errClose != nil {
+				err = errors.Wrapf(errClose, "close %s master", name)
+			}
+		}
+		if db.Slave != nil {
+			if errClose := db.Slave.Close(); errClose != nil {
+				err = errors.Wrapf(errClose, "close %s slave", name)
+			}
+		}
+		delete(dbConn, name)
+	}
+
+	return err
+}
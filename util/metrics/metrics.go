@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// labelSeparator joins labels into a single key of the metric map
+const labelSeparator = "|"
+
+var (
+	// DurationBuckets is upper bound in ms of duration histogram buckets
+	DurationBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000}
+	// SizeBuckets is upper bound of size histogram buckets
+	SizeBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+	mx         sync.Mutex
+	counters   = map[string]*expvar.Map{}
+	histograms = map[string]*expvar.Map{}
+)
+
+type (
+	// histogram is an expvar.Var of observed values with cumulative buckets, e.g.
+	// {"count": 3, "sum": 12.5, "max": 10, "buckets": {"1": 1, "5": 2, "10": 3, "+Inf": 3}}
+	histogram struct {
+		mx      sync.Mutex
+		bounds  []float64
+		buckets []int64
+		count   int64
+		sum     float64
+		max     float64
+	}
+)
+
+// IncrCounter adds delta to counter of given name and labels (e.g. connection, command).
+// Metrics are published through expvar, serve expvar.Handler() to expose them (e.g. /debug/vars).
+func IncrCounter(name string, delta int64, labels ...string) {
+	counter(name).Add(strings.Join(labels, labelSeparator), delta)
+}
+
+// ObserveDuration records duration in ms to histogram of given name and labels
+func ObserveDuration(name string, d time.Duration, labels ...string) {
+	observe(name, DurationBuckets, float64(d)/float64(time.Millisecond), labels)
+}
+
+// ObserveSize records size (e.g. number of commands) to histogram of given name and labels
+func ObserveSize(name string, size int64, labels ...string) {
+	observe(name, SizeBuckets, float64(size), labels)
+}
+
+func counter(name string) *expvar.Map {
+	mx.Lock()
+	defer mx.Unlock()
+
+	m, ok := counters[name]
+	if !ok {
+		m = expvar.NewMap(name)
+		counters[name] = m
+	}
+	return m
+}
+
+func observe(name string, bounds []float64, value float64, labels []string) {
+	mx.Lock()
+	m, ok := histograms[name]
+	if !ok {
+		m = expvar.NewMap(name)
+		histograms[name] = m
+	}
+
+	key := strings.Join(labels, labelSeparator)
+	h, ok := m.Get(key).(*histogram)
+	if !ok {
+		h = newHistogram(bounds)
+		m.Set(key, h)
+	}
+	mx.Unlock()
+
+	h.observe(value)
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds:  bounds,
+		buckets: make([]int64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(value float64) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.count++
+	h.sum += value
+	h.max = math.Max(h.max, value)
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+}
+
+// String implements expvar.Var
+func (h *histogram) String() string {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	buckets := make(map[string]int64, len(h.bounds)+1)
+	for i, bound := range h.bounds {
+		buckets[strconv.FormatFloat(bound, 'f', -1, 64)] = h.buckets[i]
+	}
+	buckets["+Inf"] = h.count
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"count":   h.count,
+		"sum":     h.sum,
+		"max":     h.max,
+		"buckets": buckets,
+	})
+	return string(data)
+}
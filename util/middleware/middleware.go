@@ -2,8 +2,10 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
 	"strings"
+	"time"
 
 	utilContext "github.com/golang-base-template/util/context"
 	"github.com/golang-base-template/util/csrf"
@@ -24,13 +26,24 @@ func ChainReq(endHandler httprouter.Handle, chains ...Chain) httprouter.Handle {
 	return chains[0](ChainReq(endHandler, chains[1:]...))
 }
 
+// InitContext keeps request context, so its cancellation and deadline reach downstream call (e.g. redis)
 var InitContext = func(next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		r = r.WithContext(context.Background())
 		next(w, r, p)
 	}
 }
 
+// Timeout set deadline to request context, redis command issued with the context fails with timeout error after it
+func Timeout(timeout time.Duration) Chain {
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next(w, r.WithContext(ctx), p)
+		}
+	}
+}
+
 // SetHeader is for add response header for common JSON api
 var SetHeader = func(next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -63,3 +76,19 @@ var CSRF = func(next httprouter.Handle) httprouter.Handle {
 		next(w, r, p)
 	}
 }
+
+// DebugAuth allows request with "Authorization: Bearer <token>" of debug endpoint,
+// every request is rejected if the token is empty
+func DebugAuth(token string) Chain {
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				resp := response.New(r.Header.Get("origin"), "true")
+				resp.WriteError(w, http.StatusUnauthorized, "Unauthenticated", "Unauthenticated")
+				return
+			}
+			next(w, r, p)
+		}
+	}
+}
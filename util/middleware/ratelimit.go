@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/golang-base-template/util/ratelimit"
+	"github.com/golang-base-template/util/response"
+)
+
+// RateLimit limits request per client using RateLimit config of given rule name.
+// Quota is counted per rule and client, so use a rule per route for per-route quota.
+// Request is passed through if the rule is not configured or the limiter cannot decide.
+func RateLimit(rule string) Chain {
+	limiter, err := ratelimit.NewLimiterFromConfig(rule)
+	if err != nil {
+		log.Printf("[Middleware][RateLimit] rate limit %s is disabled, err: %v", rule, err)
+		return func(next httprouter.Handle) httprouter.Handle {
+			return next
+		}
+	}
+
+	return RateLimitWith(limiter)
+}
+
+// RateLimitWith limits request per client using given limiter
+func RateLimitWith(limiter ratelimit.ILimiter) Chain {
+	trusted := parseTrustedProxies(limiter.Rule().TrustedProxies)
+
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			res, err := limiter.Allow(r.Context(), clientKey(r, limiter.Rule().KeyBy, trusted))
+			if err != nil {
+				log.Printf("[Middleware][RateLimit] fail to check rate limit %s, err: %v", limiter.Rule().Name, err)
+				next(w, r, p)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+			w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(ceilSecond(res.ResetAfter), 10))
+
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.FormatInt(ceilSecond(res.RetryAfter), 10))
+				resp := response.New(r.Header.Get("origin"), "true")
+				resp.WriteError(w, http.StatusTooManyRequests, "Too many requests", "Rate limit exceeded")
+				return
+			}
+
+			next(w, r, p)
+		}
+	}
+}
+
+// clientKey identifies the client by header or ip. X-Forwarded-For is only read when the request comes from
+// trusted proxy, client ip is its right-most hop which is not a trusted proxy since hops on the left can be forged.
+func clientKey(r *http.Request, keyBy string, trusted []*net.IPNet) string {
+	if strings.HasPrefix(keyBy, "header:") {
+		if v := r.Header.Get(strings.TrimPrefix(keyBy, "header:")); v != "" {
+			return v
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host, trusted) {
+		return host
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		host = hop
+		if !isTrustedProxy(hop, trusted) {
+			break
+		}
+	}
+	return host
+}
+
+// parseTrustedProxies parses ip or cidr of trusted proxies, invalid entry is ignored
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	var trusted []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			log.Printf("[Middleware][RateLimit] invalid trusted proxy %s is ignored, err: %v", proxy, err)
+			continue
+		}
+		trusted = append(trusted, ipNet)
+	}
+	return trusted
+}
+
+// isTrustedProxy reports whether ip belongs to trusted proxies
+func isTrustedProxy(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range trusted {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ceilSecond rounds duration up to second for rate limit headers
+func ceilSecond(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
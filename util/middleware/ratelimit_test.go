@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+
+	"github.com/golang-base-template/util/ratelimit"
+)
+
+// fakeLimiter return res for every request and records the key
+type fakeLimiter struct {
+	rule ratelimit.Rule
+	res  ratelimit.Result
+	err  error
+	key  string
+}
+
+func (l *fakeLimiter) Allow(ctx context.Context, key string) (ratelimit.Result, error) {
+	l.key = key
+	return l.res, l.err
+}
+
+func (l *fakeLimiter) Rule() ratelimit.Rule {
+	return l.rule
+}
+
+func TestClientKey(t *testing.T) {
+	trusted := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8", "invalid"})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		header     map[string]string
+		keyBy      string
+		want       string
+	}{
+		{
+			name:       "remote addr",
+			remoteAddr: "203.0.113.1:1234",
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "remote addr without port",
+			remoteAddr: "203.0.113.1",
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "forwarded for from untrusted client is ignored",
+			remoteAddr: "203.0.113.1:1234",
+			xff:        "198.51.100.1",
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "forwarded for from trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        "198.51.100.1",
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "forged hop on the left is skipped",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        "1.1.1.1, 198.51.100.1",
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "trusted hops on the right are skipped",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        "198.51.100.1, 192.168.1.1, 10.0.0.2",
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "every hop is trusted",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        "10.0.0.3, 10.0.0.2",
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "empty hop is skipped",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        "198.51.100.1, ",
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "trusted proxy without forwarded for",
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "ipv6 trusted proxy",
+			remoteAddr: "[fd00::1]:1234",
+			xff:        "2001:db8::1",
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "header",
+			remoteAddr: "203.0.113.1:1234",
+			header:     map[string]string{"X-User-ID": "user-1"},
+			keyBy:      "header:X-User-ID",
+			want:       "user-1",
+		},
+		{
+			name:       "missing header falls back to ip",
+			remoteAddr: "203.0.113.1:1234",
+			keyBy:      "header:X-User-ID",
+			want:       "203.0.113.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+
+			if got := clientKey(r, tt.keyBy, trusted); got != tt.want {
+				t.Errorf("clientKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitWith(t *testing.T) {
+	tests := []struct {
+		name       string
+		res        ratelimit.Result
+		err        error
+		wantStatus int
+		wantHeader map[string]string
+	}{
+		{
+			name:       "allowed",
+			res:        ratelimit.Result{Allowed: true, Limit: 10, Remaining: 9, ResetAfter: 1500 * time.Millisecond},
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{
+				"X-RateLimit-Limit":     "10",
+				"X-RateLimit-Remaining": "9",
+				"X-RateLimit-Reset":     "2",
+			},
+		},
+		{
+			name:       "denied",
+			res:        ratelimit.Result{Limit: 10, ResetAfter: time.Second, RetryAfter: 200 * time.Millisecond},
+			wantStatus: http.StatusTooManyRequests,
+			wantHeader: map[string]string{
+				"X-RateLimit-Remaining": "0",
+				"Retry-After":           "1",
+			},
+		},
+		{
+			name:       "limiter error lets request through",
+			err:        errors.New("redis is down"),
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := &fakeLimiter{
+				rule: ratelimit.Rule{Name: "test", TrustedProxies: []string{"10.0.0.0/8"}},
+				res:  tt.res,
+				err:  tt.err,
+			}
+			handler := RateLimitWith(limiter)(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "10.0.0.1:1234"
+			r.Header.Set("X-Forwarded-For", "198.51.100.1")
+			w := httptest.NewRecorder()
+			handler(w, r, nil)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			for k, v := range tt.wantHeader {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("header %s = %q, want %q", k, got, v)
+				}
+			}
+			if limiter.key != "198.51.100.1" {
+				t.Errorf("limiter key = %q, want client ip behind trusted proxy", limiter.key)
+			}
+		})
+	}
+}
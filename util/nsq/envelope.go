@@ -0,0 +1,230 @@
+package nsq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bitly/go-nsq"
+	"github.com/pkg/errors"
+)
+
+type (
+	// Message is payload of envelope, every message type has unique name and its current schema version.
+	// Bump SchemaVersion on incompatible change and register Upcaster from the previous version.
+	// Implement it on struct value receiver, its zero value is used to get type and version on decode.
+	Message interface {
+		MessageType() string
+		SchemaVersion() int
+	}
+
+	// Envelope wraps published payload with its metadata
+	Envelope struct {
+		ID         string          `json:"id"`
+		Type       string          `json:"type"`
+		Version    int             `json:"version"`
+		ProducedAt time.Time       `json:"produced_at"`
+		TraceID    string          `json:"trace_id,omitempty"`
+		Payload    json.RawMessage `json:"payload"`
+	}
+
+	// Meta is envelope metadata of the consumed message passed to typed handler.
+	// Version is the published schema version before upcasting.
+	Meta struct {
+		ID         string
+		Type       string
+		Version    int
+		ProducedAt time.Time
+		TraceID    string
+		Attempts   uint16
+	}
+
+	// Upcaster converts payload of a schema version to the next version
+	Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+	// envelopeError is returned when the message can not be decoded into the handler type,
+	// it is permanent since retrying the same message never succeeds
+	envelopeError struct {
+		err error
+	}
+
+	// typedHandler is handler of TypedHandler
+	typedHandler[T Message] func(ctx context.Context, msg T, meta Meta) error
+
+	traceKey struct{}
+)
+
+var (
+	// ErrInvalidEnvelope is returned when message is not an envelope of the handler message type
+	ErrInvalidEnvelope = errors.New("nsq: invalid message envelope")
+	// ErrUnsupportedVersion is returned when schema version of the message can not be upcasted to the handler version
+	ErrUnsupportedVersion = errors.New("nsq: unsupported message schema version")
+
+	// upcasters is upcaster of message type by the version it converts from
+	upcasters  = map[string]map[int]Upcaster{}
+	mxUpcaster sync.RWMutex
+)
+
+// RegisterUpcaster registers upcaster converting payload of the message type from fromVersion to fromVersion+1.
+// It panics if the same version is registered twice, call it from init of the message package.
+func RegisterUpcaster(msgType string, fromVersion int, fn Upcaster) {
+	mxUpcaster.Lock()
+	defer mxUpcaster.Unlock()
+
+	if upcasters[msgType] == nil {
+		upcasters[msgType] = make(map[int]Upcaster)
+	}
+	if _, exist := upcasters[msgType][fromVersion]; exist {
+		panic(fmt.Sprintf("[NSQ] RegisterUpcaster is called twice for %s version %d", msgType, fromVersion))
+	}
+	upcasters[msgType][fromVersion] = fn
+}
+
+// WithTraceID return context carrying trace id, it is written to envelope on Publish
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceID)
+}
+
+// TraceID return trace id of the context, handler context carries trace id of the consumed envelope
+func TraceID(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceKey{}).(string)
+	return traceID
+}
+
+// Publish wraps msg in envelope and publishes it to the topic on "gbt" or given connection.
+// Trace id of ctx is propagated, new trace is started if ctx has none.
+func Publish[T Message](ctx context.Context, topic string, msg T, connection ...string) error {
+	data, err := NewEnvelope(ctx, msg)
+	if err != nil {
+		return errors.Wrapf(err, "[NSQ][Publish] %s", topic)
+	}
+
+	conn := "gbt"
+	if len(connection) > 0 {
+		conn = connection[0]
+	}
+	return SendNSQTo(topic, data, conn)
+}
+
+// NewEnvelope return encoded envelope of msg, use it to publish with delay or on other backend
+func NewEnvelope[T Message](ctx context.Context, msg T) ([]byte, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[NSQ][NewEnvelope] fail to encode %s", msg.MessageType())
+	}
+
+	traceID := TraceID(ctx)
+	if traceID == "" {
+		traceID = newID()
+	}
+
+	return json.Marshal(Envelope{
+		ID:         newID(),
+		Type:       msg.MessageType(),
+		Version:    msg.SchemaVersion(),
+		ProducedAt: time.Now(),
+		TraceID:    traceID,
+		Payload:    payload,
+	})
+}
+
+// TypedHandler return handler decoding envelope into T, payload of older schema version is upcasted
+// to the version of T before fn is called. Message which is not an envelope of T, or has newer or
+// not upcastable version, fails with permanent error and is moved to dead letter topic.
+// The handler also has HandleMessageContext, so fn gets message context of consumer framework.
+func TypedHandler[T Message](fn func(ctx context.Context, msg T, meta Meta) error) nsq.Handler {
+	return typedHandler[T](fn)
+}
+
+func (fn typedHandler[T]) HandleMessage(message *nsq.Message) error {
+	return fn.HandleMessageContext(context.Background(), message)
+}
+
+func (fn typedHandler[T]) HandleMessageContext(ctx context.Context, message *nsq.Message) error {
+	msg, meta, err := Decode[T](message.Body)
+	if err != nil {
+		return &envelopeError{err: err}
+	}
+	meta.Attempts = message.Attempts
+
+	return fn(WithTraceID(ctx, meta.TraceID), msg, meta)
+}
+
+// Decode decodes envelope into T, upcasting its payload to the version of T
+func Decode[T Message](body []byte) (msg T, meta Meta, err error) {
+	var env Envelope
+	if err = json.Unmarshal(body, &env); err != nil {
+		return msg, meta, errors.Wrapf(ErrInvalidEnvelope, "[NSQ][Decode] %v", err)
+	}
+
+	meta = Meta{
+		ID:         env.ID,
+		Type:       env.Type,
+		Version:    env.Version,
+		ProducedAt: env.ProducedAt,
+		TraceID:    env.TraceID,
+	}
+
+	if env.Type != msg.MessageType() {
+		return msg, meta, errors.Wrapf(ErrInvalidEnvelope, "[NSQ][Decode] got type %q, want %q", env.Type, msg.MessageType())
+	}
+
+	payload, err := upcast(env.Type, env.Version, msg.SchemaVersion(), env.Payload)
+	if err != nil {
+		return msg, meta, err
+	}
+
+	if err = json.Unmarshal(payload, &msg); err != nil {
+		return msg, meta, errors.Wrapf(ErrInvalidEnvelope, "[NSQ][Decode] fail to decode %s payload: %v", env.Type, err)
+	}
+	return msg, meta, nil
+}
+
+// upcast converts payload version by version up to the target version
+func upcast(msgType string, version, target int, payload json.RawMessage) (json.RawMessage, error) {
+	if version > target {
+		return nil, errors.Wrapf(ErrUnsupportedVersion, "[NSQ][upcast] %s version %d is newer than %d", msgType, version, target)
+	}
+
+	mxUpcaster.RLock()
+	defer mxUpcaster.RUnlock()
+
+	for ; version < target; version++ {
+		fn, ok := upcasters[msgType][version]
+		if !ok {
+			return nil, errors.Wrapf(ErrUnsupportedVersion, "[NSQ][upcast] %s has no upcaster from version %d", msgType, version)
+		}
+
+		var err error
+		if payload, err = fn(payload); err != nil {
+			return nil, errors.Wrapf(ErrUnsupportedVersion, "[NSQ][upcast] %s from version %d: %v", msgType, version, err)
+		}
+	}
+	return payload, nil
+}
+
+// newID return random hex id of envelope and trace
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func (e *envelopeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *envelopeError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks the error as permanent for consumer dead letter handler
+func (e *envelopeError) Permanent() bool {
+	return true
+}
@@ -1,6 +1,7 @@
 package nsq
 
 import (
+	"log"
 	"time"
 
 	"github.com/pkg/errors"
@@ -76,3 +77,13 @@ func Ping() (errMap map[string]error) {
 
 	return errMap
 }
+
+// Stop stops every publisher after its in-flight publish is done, publish after Stop fails
+func Stop() {
+	for label, p := range publishers {
+		if stopper, ok := p.(interface{ Stop() }); ok {
+			stopper.Stop()
+			log.Printf("[Util][NSQ][Stop] publisher %s is stopped", label)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/golang-base-template/util/cache"
+)
+
+// localLimiter runs the same algorithm on local cache, it is used while redis is unavailable.
+// The quota is counted per pod, so the effective limit is multiplied by number of pods.
+type localLimiter struct {
+	rule Rule
+
+	mx    sync.Mutex
+	cache cache.IBigCache
+}
+
+func newLocalLimiter(rule Rule, localCache string) (*localLimiter, error) {
+	bc, err := cache.NewBigCache(localCache)
+	if err != nil {
+		return nil, errors.Wrap(err, "[RateLimit][newLocalLimiter]")
+	}
+
+	return &localLimiter{
+		rule:  rule,
+		cache: bc,
+	}, nil
+}
+
+func (l *localLimiter) allow(key string, now time.Time) (Result, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	base := keyPrefix + l.rule.Name + ":" + key
+	nowMS := now.UnixNano() / int64(time.Millisecond)
+
+	if l.rule.Algorithm == AlgorithmTokenBucket {
+		return l.tokenBucket(base, nowMS)
+	}
+	return l.slidingWindow(base, nowMS)
+}
+
+// slidingWindow mirrors slidingWindowScript
+func (l *localLimiter) slidingWindow(base string, nowMS int64) (Result, error) {
+	window := l.rule.Window.Milliseconds()
+	start := nowMS - nowMS%window
+	elapsed := nowMS - start
+	curKey := base + ":" + strconv.FormatInt(start, 10)
+
+	cur := l.get(curKey)
+	prev := l.get(base + ":" + strconv.FormatInt(start-window, 10))
+	count := int64(math.Floor(float64(prev)*float64(window-elapsed)/float64(window))) + cur
+
+	if count >= l.rule.Limit {
+		retry := window - elapsed
+		if cur < l.rule.Limit && prev > 0 {
+			retry = int64(math.Ceil(float64(window-elapsed) - float64((l.rule.Limit-cur)*window)/float64(prev)))
+		}
+		if retry < 1 {
+			retry = 1
+		}
+		return newResult(l.rule, false, 0, time.Duration(retry)*time.Millisecond, nowMS), nil
+	}
+
+	if err := l.set(curKey, uint64(cur+1), 0); err != nil {
+		return Result{}, errors.Wrap(err, "[RateLimit][slidingWindow]")
+	}
+	return newResult(l.rule, true, l.rule.Limit-count-1, 0, nowMS), nil
+}
+
+// tokenBucket mirrors tokenBucketScript
+func (l *localLimiter) tokenBucket(base string, nowMS int64) (Result, error) {
+	capacity := float64(l.rule.Burst)
+	rate := float64(l.rule.Limit) / float64(l.rule.Window.Milliseconds())
+
+	tokens, ts := capacity, nowMS
+	if data, err := l.cache.Get(base); err == nil && len(data) == 16 {
+		tokens = math.Float64frombits(binary.BigEndian.Uint64(data[:8]))
+		ts = int64(binary.BigEndian.Uint64(data[8:]))
+	}
+	if nowMS > ts {
+		tokens = math.Min(capacity, tokens+float64(nowMS-ts)*rate)
+		ts = nowMS
+	}
+
+	var (
+		allowed bool
+		retry   int64
+	)
+	if tokens >= 1 {
+		tokens--
+		allowed = true
+	} else {
+		retry = int64(math.Ceil((1 - tokens) / rate))
+	}
+
+	if err := l.set(base, math.Float64bits(tokens), ts); err != nil {
+		return Result{}, errors.Wrap(err, "[RateLimit][tokenBucket]")
+	}
+	return newResult(l.rule, allowed, int64(tokens), time.Duration(retry)*time.Millisecond, nowMS), nil
+}
+
+// get read counter of the key, missing or expired counter is zero
+func (l *localLimiter) get(key string) int64 {
+	data, err := l.cache.Get(key)
+	if err != nil || len(data) < 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(data[:8]))
+}
+
+// set write value and optional timestamp of the key, entry expiration follows bigcache life window
+func (l *localLimiter) set(key string, value uint64, ts int64) error {
+	data := make([]byte, 8, 16)
+	binary.BigEndian.PutUint64(data, value)
+	if ts > 0 {
+		data = data[:16]
+		binary.BigEndian.PutUint64(data[8:], uint64(ts))
+	}
+
+	return l.cache.Set(key, data)
+}
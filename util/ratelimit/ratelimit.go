@@ -0,0 +1,263 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/golang-base-template/util/cache"
+	redisclient "github.com/golang-base-template/util/cache/client"
+	"github.com/golang-base-template/util/config"
+)
+
+// rate limit algorithm, see RateLimitConf Algorithm
+const (
+	AlgorithmSlidingWindow = "sliding-window"
+	AlgorithmTokenBucket   = "token-bucket"
+)
+
+const (
+	keyPrefix = "ratelimit:"
+)
+
+type (
+	// ILimiter decides whether a request of a client is allowed
+	ILimiter interface {
+		// Allow consumes one request of the key (e.g. client ip), redis is used as the source of truth
+		// and local cache is used instead when redis is unavailable
+		Allow(ctx context.Context, key string) (Result, error)
+		Rule() Rule
+	}
+
+	// Rule is quota of a rate limit rule
+	Rule struct {
+		Name      string
+		Algorithm string
+		// Limit is number of requests allowed per Window
+		Limit  int64
+		Window time.Duration
+		// Burst is bucket capacity of token bucket, default to Limit
+		Burst int64
+		// KeyBy identifies the client, either "ip" or "header:<name>"
+		KeyBy string
+		// TrustedProxies is ip or cidr of proxies whose X-Forwarded-For is trusted
+		TrustedProxies []string
+	}
+
+	// Result is decision of a request
+	Result struct {
+		Allowed   bool
+		Limit     int64
+		Remaining int64
+		// ResetAfter is duration until the quota is fully restored
+		ResetAfter time.Duration
+		// RetryAfter is duration until next request is allowed, zero if the request is allowed
+		RetryAfter time.Duration
+	}
+
+	limiter struct {
+		rule  Rule
+		conn  string
+		local *localLimiter
+	}
+)
+
+var (
+	// ErrRuleNotFound is returned when rate limit rule is not configured
+	ErrRuleNotFound = errors.New("ratelimit: rule is not configured")
+	// ErrInvalidRule is returned when limit or window of the rule is not positive or the algorithm is unknown
+	ErrInvalidRule = errors.New("ratelimit: invalid rule")
+
+	// slidingWindowScript approximates sliding window by weighting previous fixed window count with its overlap.
+	// KEYS[1] is current window counter, KEYS[2] is previous window counter.
+	// ARGV[1] is limit, ARGV[2] is window in ms, ARGV[3] is elapsed ms of current window.
+	// Return {allowed, remaining, retry after ms}
+	slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local elapsed = tonumber(ARGV[3])
+local cur = tonumber(redis.call("get", KEYS[1]) or "0")
+local prev = tonumber(redis.call("get", KEYS[2]) or "0")
+local count = math.floor(prev * (window - elapsed) / window) + cur
+if count >= limit then
+	local retry = window - elapsed
+	if cur < limit and prev > 0 then
+		retry = math.ceil((window - elapsed) - (limit - cur) * window / prev)
+	end
+	if retry < 1 then
+		retry = 1
+	end
+	return {0, 0, retry}
+end
+redis.call("incr", KEYS[1])
+redis.call("pexpire", KEYS[1], window * 2)
+return {1, limit - count - 1, 0}`)
+
+	// tokenBucketScript refills the bucket by elapsed time then takes a token.
+	// KEYS[1] is bucket hash.
+	// ARGV[1] is capacity, ARGV[2] is limit, ARGV[3] is window in ms, ARGV[4] is now in ms.
+	// Return {allowed, remaining, retry after ms}
+	tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2]) / tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+local bucket = redis.call("hmget", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) * rate)
+	ts = now
+end
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+redis.call("hmset", KEYS[1], "tokens", tostring(tokens), "ts", ts)
+redis.call("pexpire", KEYS[1], math.ceil(capacity / rate) + 1000)
+return {allowed, math.floor(tokens), retry}`)
+)
+
+// NewLimiterFromConfig return limiter of RateLimit config of given name, ErrRuleNotFound is returned if it is not configured
+func NewLimiterFromConfig(name string) (ILimiter, error) {
+	conf, ok := config.Get().RateLimit[name]
+	if !ok || conf == nil {
+		return nil, errors.Wrapf(ErrRuleNotFound, "[RateLimit][NewLimiterFromConfig] rule: %s", name)
+	}
+
+	rule := Rule{
+		Name:      name,
+		Algorithm: conf.Algorithm,
+		Limit:     int64(conf.Limit),
+		Window:    time.Duration(conf.WindowSec) * time.Second,
+		Burst:     int64(conf.Burst),
+		KeyBy:     conf.KeyBy,
+
+		TrustedProxies: conf.TrustedProxies,
+	}
+
+	return NewLimiter(rule, conf.Redis, conf.LocalCache)
+}
+
+// NewLimiter return limiter of the rule using given redis connection (default CacheGBT)
+// and bigcache config as local fallback (default bigcache config if empty)
+func NewLimiter(rule Rule, conn, localCache string) (ILimiter, error) {
+	if rule.Algorithm == "" {
+		rule.Algorithm = AlgorithmSlidingWindow
+	}
+	if rule.Burst <= 0 {
+		rule.Burst = rule.Limit
+	}
+	if rule.Limit <= 0 || rule.Window <= 0 ||
+		(rule.Algorithm != AlgorithmSlidingWindow && rule.Algorithm != AlgorithmTokenBucket) {
+		return nil, errors.Wrapf(ErrInvalidRule, "[RateLimit][NewLimiter] rule: %s", rule.Name)
+	}
+
+	if conn == "" {
+		conn = cache.CacheGBT
+	}
+
+	local, err := newLocalLimiter(rule, localCache)
+	if err != nil {
+		return nil, errors.Wrap(err, "[RateLimit][NewLimiter]")
+	}
+
+	return &limiter{
+		rule:  rule,
+		conn:  conn,
+		local: local,
+	}, nil
+}
+
+func (l *limiter) Rule() Rule {
+	return l.rule
+}
+
+func (l *limiter) Allow(ctx context.Context, key string) (Result, error) {
+	now := time.Now()
+
+	res, err := l.allowRedis(ctx, key, now)
+	if err == nil {
+		return res, nil
+	}
+	if ctx.Err() != nil {
+		return Result{}, errors.Wrap(ctx.Err(), "[RateLimit][Allow]")
+	}
+
+	log.Printf("[RateLimit][Allow] fail to check %s on redis, fallback to local limit, err: %v", l.rule.Name, err)
+	return l.local.allow(key, now)
+}
+
+// allowRedis run the algorithm script on redis
+func (l *limiter) allowRedis(ctx context.Context, key string, now time.Time) (Result, error) {
+	rds, err := redisclient.GetConnection(l.conn)
+	if err != nil {
+		return Result{}, errors.Wrap(cache.ErrConn, err.Error())
+	}
+
+	var reply interface{}
+	window := l.rule.Window.Milliseconds()
+	nowMS := now.UnixNano() / int64(time.Millisecond)
+	// hash tag keeps every key of a client on the same cluster slot
+	base := keyPrefix + "{" + l.rule.Name + ":" + key + "}"
+
+	switch l.rule.Algorithm {
+	case AlgorithmTokenBucket:
+		reply, err = tokenBucketScript.Run(ctx, rds, []string{base},
+			l.rule.Burst, l.rule.Limit, window, nowMS).Result()
+	default:
+		start := nowMS - nowMS%window
+		reply, err = slidingWindowScript.Run(ctx, rds,
+			[]string{base + ":" + strconv.FormatInt(start, 10), base + ":" + strconv.FormatInt(start-window, 10)},
+			l.rule.Limit, window, nowMS-start).Result()
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 3 {
+		return Result{}, errors.Errorf("[RateLimit][allowRedis] unexpected reply %v", reply)
+	}
+
+	return newResult(l.rule, toInt64(values[0]) == 1, toInt64(values[1]), time.Duration(toInt64(values[2]))*time.Millisecond, nowMS), nil
+}
+
+// newResult fills limit and reset time of a decision
+func newResult(rule Rule, allowed bool, remaining int64, retryAfter time.Duration, nowMS int64) Result {
+	res := Result{
+		Allowed:    allowed,
+		Limit:      rule.Limit,
+		Remaining:  remaining,
+		RetryAfter: retryAfter,
+	}
+
+	window := rule.Window.Milliseconds()
+	switch rule.Algorithm {
+	case AlgorithmTokenBucket:
+		res.Limit = rule.Burst
+		// time to refill the bucket to its capacity
+		res.ResetAfter = time.Duration(float64(rule.Burst-remaining)*float64(window)/float64(rule.Limit)) * time.Millisecond
+	default:
+		res.ResetAfter = time.Duration(window-nowMS%window) * time.Millisecond
+	}
+
+	return res
+}
+
+// toInt64 converts lua integer reply into int64
+func toInt64(v interface{}) int64 {
+	n, _ := v.(int64)
+	return n
+}
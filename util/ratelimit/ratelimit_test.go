@@ -0,0 +1,190 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pkg/errors"
+
+	"github.com/golang-base-template/util/cache"
+	redisclient "github.com/golang-base-template/util/cache/client"
+)
+
+// request is a call of Allow at offset from start of a window, with its expected decision
+type request struct {
+	at         time.Duration
+	allowed    bool
+	remaining  int64
+	retryAfter time.Duration
+}
+
+// newTestRedis points CacheGBT connection to a fresh miniredis
+func newTestRedis(t *testing.T) *miniredis.Miniredis {
+	mr := miniredis.RunT(t)
+	redisclient.RedisClients = redisclient.RedisConnsMap{
+		cache.CacheGBT: redisclient.NewConnection(cache.CacheGBT, mr.Addr(), ""),
+	}
+	return mr
+}
+
+func TestAlgorithms(t *testing.T) {
+	ctx := context.Background()
+	// start of a minute window, so sliding window offsets are deterministic
+	start := time.Unix(1700000040, 0)
+
+	tests := []struct {
+		name     string
+		rule     Rule
+		requests []request
+	}{
+		{
+			name: "sliding window within a window",
+			rule: Rule{Name: "test", Algorithm: AlgorithmSlidingWindow, Limit: 3, Window: time.Minute},
+			requests: []request{
+				{at: 0, allowed: true, remaining: 2},
+				{at: time.Second, allowed: true, remaining: 1},
+				{at: 2 * time.Second, allowed: true, remaining: 0},
+				{at: 3 * time.Second, allowed: false, remaining: 0, retryAfter: 57 * time.Second},
+			},
+		},
+		{
+			name: "sliding window weights previous window",
+			rule: Rule{Name: "test", Algorithm: AlgorithmSlidingWindow, Limit: 4, Window: time.Minute},
+			requests: []request{
+				{at: 0, allowed: true, remaining: 3},
+				{at: 0, allowed: true, remaining: 2},
+				{at: 0, allowed: true, remaining: 1},
+				{at: 0, allowed: true, remaining: 0},
+				// half of the next window, previous 4 requests count as 2
+				{at: 90 * time.Second, allowed: true, remaining: 1},
+				{at: 90 * time.Second, allowed: true, remaining: 0},
+				// weight of previous window is floored, it drops below 2 right after
+				{at: 90 * time.Second, allowed: false, retryAfter: time.Millisecond},
+				{at: 90*time.Second + time.Millisecond, allowed: true, remaining: 0},
+				// previous window counts as 1 with 3 of current window, it counts as 0 after 5s
+				{at: 100 * time.Second, allowed: false, retryAfter: 5 * time.Second},
+			},
+		},
+		{
+			name: "token bucket",
+			rule: Rule{Name: "test", Algorithm: AlgorithmTokenBucket, Limit: 1, Window: time.Second, Burst: 2},
+			requests: []request{
+				{at: 0, allowed: true, remaining: 1},
+				{at: 0, allowed: true, remaining: 0},
+				{at: 500 * time.Millisecond, allowed: false, retryAfter: 500 * time.Millisecond},
+				{at: time.Second, allowed: true, remaining: 0},
+				// bucket is refilled up to its capacity
+				{at: 10 * time.Second, allowed: true, remaining: 1},
+			},
+		},
+	}
+
+	backends := []struct {
+		name  string
+		allow func(l *limiter, now time.Time) (Result, error)
+	}{
+		{
+			name: "redis",
+			allow: func(l *limiter, now time.Time) (Result, error) {
+				return l.allowRedis(ctx, "client", now)
+			},
+		},
+		{
+			name: "local",
+			allow: func(l *limiter, now time.Time) (Result, error) {
+				return l.local.allow("client", now)
+			},
+		},
+	}
+
+	for _, backend := range backends {
+		for _, tt := range tests {
+			t.Run(backend.name+" "+tt.name, func(t *testing.T) {
+				newTestRedis(t)
+				l, err := NewLimiter(tt.rule, "", "")
+				if err != nil {
+					t.Fatalf("NewLimiter err = %v", err)
+				}
+
+				for i, req := range tt.requests {
+					res, err := backend.allow(l.(*limiter), start.Add(req.at))
+					if err != nil {
+						t.Fatalf("request %d err = %v", i, err)
+					}
+					if res.Allowed != req.allowed || res.Remaining != req.remaining || res.RetryAfter != req.retryAfter {
+						t.Errorf("request %d = allowed %v, remaining %d, retry after %v, want %v, %d, %v",
+							i, res.Allowed, res.Remaining, res.RetryAfter, req.allowed, req.remaining, req.retryAfter)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestAllowKeyIsolation(t *testing.T) {
+	ctx := context.Background()
+	newTestRedis(t)
+
+	l, err := NewLimiter(Rule{Name: "test", Algorithm: AlgorithmTokenBucket, Limit: 1, Window: time.Hour}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"client-a", "client-b"} {
+		if res, err := l.Allow(ctx, key); err != nil || !res.Allowed {
+			t.Errorf("first request of %s = %+v, err = %v, want allowed", key, res, err)
+		}
+	}
+	if res, err := l.Allow(ctx, "client-a"); err != nil || res.Allowed {
+		t.Errorf("second request of client-a = %+v, err = %v, want denied", res, err)
+	}
+}
+
+func TestAllowLocalFallback(t *testing.T) {
+	ctx := context.Background()
+	mr := newTestRedis(t)
+
+	l, err := NewLimiter(Rule{Name: "test", Algorithm: AlgorithmTokenBucket, Limit: 1, Window: time.Hour, Burst: 2}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// redis is unavailable, the quota is counted on local cache
+	mr.Close()
+	for i, want := range []bool{true, true, false} {
+		res, err := l.Allow(ctx, "client")
+		if err != nil {
+			t.Fatalf("request %d err = %v", i, err)
+		}
+		if res.Allowed != want {
+			t.Errorf("request %d allowed = %v, want %v", i, res.Allowed, want)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := l.Allow(cancelled, "client"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Allow with cancelled ctx err = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestNewLimiterInvalidRule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{name: "no limit", rule: Rule{Name: "test", Window: time.Minute}},
+		{name: "no window", rule: Rule{Name: "test", Limit: 1}},
+		{name: "unknown algorithm", rule: Rule{Name: "test", Algorithm: "fixed-window", Limit: 1, Window: time.Minute}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewLimiter(tt.rule, "", ""); !errors.Is(err, ErrInvalidRule) {
+				t.Errorf("err = %v, want %v", err, ErrInvalidRule)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package stream
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitly/go-nsq"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	redisclient "github.com/golang-base-template/util/cache/client"
+)
+
+const (
+	defaultCount         = 1
+	defaultMaxDeliveries = 5
+	defaultClaimMinIdle  = time.Minute
+	deadLetterSuffix     = ":dead-letter"
+
+	// blockTimeout bounds XREADGROUP so pending messages of crashed workers are reclaimed on time
+	blockTimeout = 2 * time.Second
+	// retryDelay is wait time after redis error before reading again
+	retryDelay = time.Second
+)
+
+type (
+	// Config is redis stream consumer config
+	Config struct {
+		// Count is max number of messages read at once, default 1
+		Count int64
+		// MaxDeliveries is number of deliveries after which the message is moved to DeadLetter stream, default 5
+		MaxDeliveries int64
+		// ClaimMinIdle is how long a message stays pending (e.g. its worker crashed or requeued it)
+		// before it is reclaimed and delivered again, default 1 minute
+		ClaimMinIdle time.Duration
+		// DeadLetter is stream of messages exceeding MaxDeliveries, default <stream>:dead-letter
+		DeadLetter string
+	}
+
+	// Consumer consumes a redis stream as a member of consumer group.
+	// Message is passed to nsq.Handler, so the same handler serves both nsq and redis stream:
+	// Finish acknowledges the message (XACK), Requeue leaves it pending until it is reclaimed after ClaimMinIdle
+	// and Touch resets its idle time.
+	Consumer struct {
+		conn   string
+		stream string
+		group  string
+		name   string
+		cfg    Config
+
+		handler nsq.Handler
+
+		mx     sync.Mutex
+		cancel context.CancelFunc
+		// StopChan is closed once the consumer stops after Stop is called, like nsq.Consumer StopChan
+		StopChan chan int
+	}
+
+	// delegate responds to message of the consumer, see nsq.MessageDelegate
+	delegate struct {
+		consumer *Consumer
+		id       string
+	}
+)
+
+// NewConsumer return consumer of the stream as given consumer name of the group.
+// Consumer name must be unique in the group (e.g. hostname and worker number).
+func NewConsumer(conn, stream, group, name string, cfg Config) (*Consumer, error) {
+	if _, err := redisclient.GetConnection(conn); err != nil {
+		return nil, errors.Wrap(ErrConn, "[Stream][NewConsumer]")
+	}
+
+	if cfg.Count <= 0 {
+		cfg.Count = defaultCount
+	}
+	if cfg.MaxDeliveries <= 0 {
+		cfg.MaxDeliveries = defaultMaxDeliveries
+	}
+	if cfg.ClaimMinIdle <= 0 {
+		cfg.ClaimMinIdle = defaultClaimMinIdle
+	}
+	if cfg.DeadLetter == "" {
+		cfg.DeadLetter = DeadLetterStream(stream)
+	}
+
+	return &Consumer{
+		conn:     conn,
+		stream:   stream,
+		group:    group,
+		name:     name,
+		cfg:      cfg,
+		StopChan: make(chan int),
+	}, nil
+}
+
+// AddHandler sets handler of the consumer, it must be called before Start
+func (c *Consumer) AddHandler(handler nsq.Handler) {
+	c.handler = handler
+}
+
+// Start creates the consumer group (and the stream) if it does not exist and consumes in background
+func (c *Consumer) Start() error {
+	if c.handler == nil {
+		return errors.New("[Stream][Start] no handler is added")
+	}
+
+	rds, err := redisclient.GetConnection(c.conn)
+	if err != nil {
+		return errors.Wrap(ErrConn, "[Stream][Start]")
+	}
+
+	// new group starts from the beginning so messages published before the first consumer are not lost
+	err = rds.XGroupCreateMkStream(context.Background(), c.stream, c.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return errors.Wrapf(err, "[Stream][Start] fail to create group %s of %s", c.group, c.stream)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mx.Lock()
+	c.cancel = cancel
+	c.mx.Unlock()
+
+	go c.run(ctx, rds)
+	return nil
+}
+
+// Stop stops reading new message, wait for StopChan to make sure the message in flight is handled
+func (c *Consumer) Stop() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+func (c *Consumer) run(ctx context.Context, rds redisclient.Redis) {
+	defer close(c.StopChan)
+
+	block := blockTimeout
+	if c.cfg.ClaimMinIdle < block {
+		block = c.cfg.ClaimMinIdle
+	}
+
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= c.cfg.ClaimMinIdle {
+			lastClaim = time.Now()
+			if err := c.reclaim(ctx, rds); err != nil && ctx.Err() == nil {
+				log.Printf("[Stream][Consumer] fail to reclaim pending message of %s, err: %v", c.stream, err)
+			}
+		}
+
+		streams, err := rds.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.name,
+			Streams:  []string{c.stream, ">"},
+			Count:    c.cfg.Count,
+			Block:    block,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("[Stream][Consumer] fail to read %s, err: %v", c.stream, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryDelay):
+			}
+			continue
+		}
+
+		for _, s := range streams {
+			for _, msg := range s.Messages {
+				c.handle(msg, 1)
+			}
+		}
+	}
+}
+
+// reclaim claims messages pending longer than ClaimMinIdle, including the ones of crashed workers,
+// and moves the ones exceeding MaxDeliveries to dead letter stream
+func (c *Consumer) reclaim(ctx context.Context, rds redisclient.Redis) error {
+	start := "0-0"
+	for ctx.Err() == nil {
+		msgs, next, err := rds.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   c.stream,
+			Group:    c.group,
+			Consumer: c.name,
+			MinIdle:  c.cfg.ClaimMinIdle,
+			Start:    start,
+			Count:    c.cfg.Count,
+		}).Result()
+		if err != nil {
+			return errors.Wrap(err, "[Stream][reclaim]")
+		}
+
+		deliveries, err := c.deliveries(ctx, rds, msgs)
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range msgs {
+			n := deliveries[msg.ID]
+			if n > c.cfg.MaxDeliveries {
+				c.deadLetter(rds, msg, n)
+				continue
+			}
+			c.handle(msg, n)
+		}
+
+		if next == "0-0" {
+			return nil
+		}
+		start = next
+	}
+	return nil
+}
+
+// deliveries return delivery count of the claimed messages, claiming counts as a delivery
+func (c *Consumer) deliveries(ctx context.Context, rds redisclient.Redis, msgs []redis.XMessage) (map[string]int64, error) {
+	res := make(map[string]int64, len(msgs))
+	if len(msgs) == 0 {
+		return res, nil
+	}
+
+	cmds, err := rds.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, msg := range msgs {
+			pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: c.stream,
+				Group:  c.group,
+				Start:  msg.ID,
+				End:    msg.ID,
+				Count:  1,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "[Stream][deliveries]")
+	}
+
+	for _, cmd := range cmds {
+		pending, _ := cmd.(*redis.XPendingExtCmd).Result()
+		for _, p := range pending {
+			res[p.ID] = p.RetryCount
+		}
+	}
+	for _, msg := range msgs {
+		if res[msg.ID] == 0 {
+			res[msg.ID] = 1
+		}
+	}
+	return res, nil
+}
+
+// handle passes the message to the handler and responds the same way nsq.Consumer does:
+// unless auto response is disabled, the message is finished on success and requeued on error
+func (c *Consumer) handle(msg redis.XMessage, deliveries int64) {
+	body, _ := msg.Values[fieldBody].(string)
+
+	var id nsq.MessageID
+	copy(id[:], msg.ID)
+	message := nsq.NewMessage(id, []byte(body))
+	message.Attempts = uint16(deliveries)
+	message.Delegate = &delegate{consumer: c, id: msg.ID}
+	if ms, err := strconv.ParseInt(strings.SplitN(msg.ID, "-", 2)[0], 10, 64); err == nil {
+		message.Timestamp = ms * int64(time.Millisecond)
+	}
+
+	err := c.handler.HandleMessage(message)
+	if message.IsAutoResponseDisabled() {
+		return
+	}
+	if err != nil {
+		message.Requeue(-1)
+		return
+	}
+	message.Finish()
+}
+
+// deadLetter moves the message to dead letter stream and acknowledges it
+func (c *Consumer) deadLetter(rds redisclient.Redis, msg redis.XMessage, deliveries int64) {
+	// the claim moving it here is not delivered to the handler
+	deliveries--
+
+	ctx := context.Background()
+	body, _ := msg.Values[fieldBody].(string)
+	err := addDeadLetter(ctx, rds, c.cfg.DeadLetter, DeadLetterEntry{
+		ID:         msg.ID,
+		Stream:     c.stream,
+		Group:      c.group,
+		Body:       []byte(body),
+		Deliveries: deliveries,
+	})
+	if err != nil {
+		log.Printf("[Stream][Consumer] fail to move message %s of %s to dead letter, err: %v", msg.ID, c.stream, err)
+		return
+	}
+
+	log.Printf("[Stream][Consumer] message %s of %s is moved to %s after %d deliveries", msg.ID, c.stream, c.cfg.DeadLetter, deliveries)
+	err = rds.XAck(ctx, c.stream, c.group, msg.ID).Err()
+	if err != nil {
+		log.Printf("[Stream][Consumer] fail to ack dead letter message %s of %s, err: %v", msg.ID, c.stream, err)
+	}
+}
+
+// EntryID return stream entry id of the message consumed from redis stream,
+// nsq.Message ID only keeps its first 16 bytes
+func EntryID(message *nsq.Message) string {
+	if d, ok := message.Delegate.(*delegate); ok {
+		return d.id
+	}
+	return string(message.ID[:])
+}
+
+func (d *delegate) OnFinish(message *nsq.Message) {
+	rds, err := redisclient.GetConnection(d.consumer.conn)
+	if err != nil {
+		log.Printf("[Stream][Consumer] fail to ack message %s, err: %v", d.id, err)
+		return
+	}
+
+	err = rds.XAck(context.Background(), d.consumer.stream, d.consumer.group, d.id).Err()
+	if err != nil {
+		log.Printf("[Stream][Consumer] fail to ack message %s of %s, err: %v", d.id, d.consumer.stream, err)
+	}
+}
+
+// OnRequeue leaves the message pending, it is delivered again once it is reclaimed after ClaimMinIdle
+// so the requeue delay is not honored
+func (d *delegate) OnRequeue(message *nsq.Message, delay time.Duration, backoff bool) {}
+
+// OnTouch resets idle time of the message so it is not reclaimed by other worker
+func (d *delegate) OnTouch(message *nsq.Message) {
+	rds, err := redisclient.GetConnection(d.consumer.conn)
+	if err != nil {
+		log.Printf("[Stream][Consumer] fail to touch message %s, err: %v", d.id, err)
+		return
+	}
+
+	err = rds.XClaimJustID(context.Background(), &redis.XClaimArgs{
+		Stream:   d.consumer.stream,
+		Group:    d.consumer.group,
+		Consumer: d.consumer.name,
+		Messages: []string{d.id},
+	}).Err()
+	if err != nil {
+		log.Printf("[Stream][Consumer] fail to touch message %s of %s, err: %v", d.id, d.consumer.stream, err)
+	}
+}
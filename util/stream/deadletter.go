@@ -0,0 +1,100 @@
+package stream
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	redisclient "github.com/golang-base-template/util/cache/client"
+)
+
+// DeadLetterEntry is an entry of dead letter stream
+type DeadLetterEntry struct {
+	// EntryID is id of the dead letter entry, ID is id of the original message
+	EntryID    string
+	ID         string
+	Stream     string
+	Group      string
+	Body       []byte
+	Deliveries int64
+	Error      string
+}
+
+// DeadLetterStream return default dead letter stream of the stream
+func DeadLetterStream(stream string) string {
+	return stream + deadLetterSuffix
+}
+
+// AddDeadLetter appends the entry to dead letter stream
+func AddDeadLetter(ctx context.Context, conn, deadLetter string, entry DeadLetterEntry) error {
+	rds, err := redisclient.GetConnection(conn)
+	if err != nil {
+		return errors.Wrap(ErrConn, "[Stream][AddDeadLetter]")
+	}
+	return addDeadLetter(ctx, rds, deadLetter, entry)
+}
+
+func addDeadLetter(ctx context.Context, rds redisclient.Redis, deadLetter string, entry DeadLetterEntry) error {
+	values := []interface{}{
+		fieldBody, entry.Body,
+		fieldID, entry.ID,
+		fieldStream, entry.Stream,
+		fieldGroup, entry.Group,
+		fieldDeliveries, entry.Deliveries,
+	}
+	if entry.Error != "" {
+		values = append(values, fieldError, entry.Error)
+	}
+
+	err := rds.XAdd(ctx, &redis.XAddArgs{
+		Stream: deadLetter,
+		Values: values,
+	}).Err()
+	if err != nil {
+		return errors.Wrapf(err, "[Stream][AddDeadLetter] fail to add to %s", deadLetter)
+	}
+	return nil
+}
+
+// ReadDeadLetter return up to count oldest entries of dead letter stream
+func ReadDeadLetter(ctx context.Context, conn, deadLetter string, count int64) ([]DeadLetterEntry, error) {
+	rds, err := redisclient.GetConnection(conn)
+	if err != nil {
+		return nil, errors.Wrap(ErrConn, "[Stream][ReadDeadLetter]")
+	}
+
+	msgs, err := rds.XRangeN(ctx, deadLetter, "-", "+", count).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "[Stream][ReadDeadLetter] fail to read %s", deadLetter)
+	}
+
+	res := make([]DeadLetterEntry, 0, len(msgs))
+	for _, msg := range msgs {
+		entry := DeadLetterEntry{EntryID: msg.ID}
+		entry.ID, _ = msg.Values[fieldID].(string)
+		entry.Stream, _ = msg.Values[fieldStream].(string)
+		entry.Group, _ = msg.Values[fieldGroup].(string)
+		entry.Error, _ = msg.Values[fieldError].(string)
+		body, _ := msg.Values[fieldBody].(string)
+		entry.Body = []byte(body)
+		deliveries, _ := msg.Values[fieldDeliveries].(string)
+		entry.Deliveries, _ = strconv.ParseInt(deliveries, 10, 64)
+		res = append(res, entry)
+	}
+	return res, nil
+}
+
+// RemoveDeadLetter deletes the entries of dead letter stream by EntryID
+func RemoveDeadLetter(ctx context.Context, conn, deadLetter string, entryIDs ...string) error {
+	rds, err := redisclient.GetConnection(conn)
+	if err != nil {
+		return errors.Wrap(ErrConn, "[Stream][RemoveDeadLetter]")
+	}
+
+	if err = rds.XDel(ctx, deadLetter, entryIDs...).Err(); err != nil {
+		return errors.Wrapf(err, "[Stream][RemoveDeadLetter] fail to remove from %s", deadLetter)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package stream
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	redisclient "github.com/golang-base-template/util/cache/client"
+)
+
+// Backend is ConsumerListConfig Backend of redis stream consumer
+const Backend = "redis-stream"
+
+// stream entry fields
+const (
+	fieldBody = "body"
+
+	// dead letter entry also keeps where the message came from
+	fieldID         = "id"
+	fieldStream     = "stream"
+	fieldGroup      = "group"
+	fieldDeliveries = "deliveries"
+	fieldError      = "error"
+)
+
+var (
+	// ErrConn is returned when redis connection of the stream is not initiated
+	ErrConn = errors.New("stream: redis connection does not exist")
+)
+
+// Publish appends body to the stream and return its entry id
+func Publish(ctx context.Context, conn, stream string, body []byte) (id string, err error) {
+	rds, err := redisclient.GetConnection(conn)
+	if err != nil {
+		return "", errors.Wrap(ErrConn, "[Stream][Publish]")
+	}
+
+	id, err = rds.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		Values: []interface{}{fieldBody, body},
+	}).Result()
+	if err != nil {
+		return "", errors.Wrapf(err, "[Stream][Publish] fail to publish to %s", stream)
+	}
+	return id, nil
+}